@@ -3,6 +3,7 @@ package cmd
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -13,6 +14,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -362,23 +364,26 @@ func (c *APIClient) RunCommand(instanceName, command string) (string, error) {
 	return apiResp.Data.Output, nil
 }
 
-func (c *APIClient) StreamCommand(instanceName, command string, out io.Writer) error {
+// StreamCommand runs command over the exec SSE endpoint, writing stdout and
+// stderr events to the matching writers, and returns the remote exit code.
+// Cancelling ctx abandons the stream.
+func (c *APIClient) StreamCommand(ctx context.Context, instanceName, command string, stdout, stderr io.Writer) (int, error) {
 	if instanceName == "" {
 		instanceName = "auto"
 	}
 
 	u, err := url.Parse(c.BaseURL)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	u.Path = strings.TrimRight(u.Path, "/") + fmt.Sprintf("/instance/%s/exec/sse", instanceName)
 	q := u.Query()
 	q.Set("input", command)
 	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	req.Header.Set("Accept", "text/event-stream")
@@ -391,43 +396,58 @@ func (c *APIClient) StreamCommand(instanceName, command string, out io.Writer) e
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to stream exec: %d - %s", resp.StatusCode, string(body))
+		return 0, fmt.Errorf("failed to stream exec: %d - %s", resp.StatusCode, string(body))
 	}
 
 	reader := bufio.NewReader(resp.Body)
 	var eventType string
 	var dataBuf strings.Builder
+	exitCode, haveExit, failed := 0, false, false
 
-	flushEvent := func(ev, data string) (bool, error) {
+	write := func(w io.Writer, data string) {
+		if data != "" {
+			fmt.Fprint(w, data)
+			if !strings.HasSuffix(data, "\n") {
+				fmt.Fprintln(w)
+			}
+		}
+	}
+	flushEvent := func(ev, data string) bool {
 		switch ev {
 		case "open":
 			// Suppress "starting" noise — just wait for output
+		case "exit":
+			exitCode, haveExit = parseExitCode(data)
 		case "done":
-			return true, nil
-		default:
-			if data != "" {
-				fmt.Fprint(out, data)
-				if !strings.HasSuffix(data, "\n") {
-					fmt.Fprintln(out)
-				}
+			if code, ok := parseExitCode(data); ok {
+				exitCode, haveExit = code, true
 			}
+			return true
+		case "stderr":
+			write(stderr, data)
+		case "error":
+			write(stderr, data)
+			failed = true
+		default:
+			write(stdout, data)
 		}
-		return false, nil
+		return false
 	}
 
-	for {
+	done := false
+	for !done {
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return 0, err
 		}
 		line = strings.TrimRight(line, "\r\n")
 
@@ -436,13 +456,7 @@ func (c *APIClient) StreamCommand(instanceName, command string, out io.Writer) e
 			if eventType == "" {
 				eventType = "message"
 			}
-			done, err := flushEvent(eventType, data)
-			if err != nil {
-				return err
-			}
-			if done {
-				break
-			}
+			done = flushEvent(eventType, data)
 			dataBuf.Reset()
 			eventType = ""
 			continue
@@ -459,12 +473,45 @@ func (c *APIClient) StreamCommand(instanceName, command string, out io.Writer) e
 			if dataBuf.Len() > 0 {
 				dataBuf.WriteByte('\n')
 			}
-			dataBuf.WriteString(strings.TrimSpace(line[len("data:"):]))
+			// Only the single space after the colon is framing; keep the
+			// indentation of output lines
+			dataBuf.WriteString(strings.TrimPrefix(line[len("data:"):], " "))
 			continue
 		}
 	}
 
-	return nil
+	switch {
+	case haveExit:
+		return exitCode, nil
+	case !done:
+		return 0, fmt.Errorf("exec stream ended before the command finished")
+	case failed:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// parseExitCode reads an exit status from exit or done event data: a bare
+// number, or a JSON object with exit_code or code.
+func parseExitCode(data string) (int, bool) {
+	data = strings.TrimSpace(data)
+	if code, err := strconv.Atoi(data); err == nil {
+		return code, true
+	}
+	var obj struct {
+		ExitCode *int `json:"exit_code"`
+		Code     *int `json:"code"`
+	}
+	if json.Unmarshal([]byte(data), &obj) != nil {
+		return 0, false
+	}
+	if obj.ExitCode != nil {
+		return *obj.ExitCode, true
+	}
+	if obj.Code != nil {
+		return *obj.Code, true
+	}
+	return 0, false
 }
 
 // StreamLogs connects to the server-side logs SSE endpoint and streams output.
@@ -910,9 +957,14 @@ func (c *APIClient) DeployStatus(name, workflowID string) (map[string]any, error
 	return apiResp.Data, nil
 }
 
-// Rollback restores an instance to its most recent pre-deploy snapshot.
-func (c *APIClient) Rollback(name string) (map[string]any, error) {
-	resp, err := c.makeRequest("POST", "/instance/"+name+"/rollback", nil)
+// Rollback restores an instance to a pre-deploy snapshot. An empty snapshot
+// restores the most recent one.
+func (c *APIClient) Rollback(name, snapshot string) (map[string]any, error) {
+	var body any
+	if snapshot != "" {
+		body = map[string]any{"snapshot": snapshot}
+	}
+	resp, err := c.makeRequest("POST", "/instance/"+name+"/rollback", body)
 	if err != nil {
 		return nil, err
 	}
@@ -930,18 +982,29 @@ func (c *APIClient) Rollback(name string) (map[string]any, error) {
 	return apiResp.Data, nil
 }
 
-// CreateDeploySnapshot takes a pre-deploy snapshot of an instance.
-func (c *APIClient) CreateDeploySnapshot(name string) error {
-	resp, err := c.makeRequest("POST", "/instance/"+name+"/snapshot", nil)
+// CreateDeploySnapshot takes a pre-deploy snapshot of an instance and
+// returns its name, or "" if the server does not report one. metadata
+// (message, git commit, author, CLI version) is stored with the snapshot and
+// returned by DeployHistory; it may be nil.
+func (c *APIClient) CreateDeploySnapshot(name string, metadata map[string]any) (string, error) {
+	var body any
+	if len(metadata) > 0 {
+		body = metadata
+	}
+	resp, err := c.makeRequest("POST", "/instance/"+name+"/snapshot", body)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("snapshot failed: %s", string(body))
+		return "", fmt.Errorf("snapshot failed: %s", string(body))
 	}
-	return nil
+	var apiResp struct {
+		Data map[string]any `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&apiResp)
+	return deploySnapshotName(apiResp.Data), nil
 }
 
 // DeployHistory lists pre-deploy snapshots for an instance.
@@ -1005,6 +1068,7 @@ func (c *APIClient) StreamDeployEvents(name, workflowID string, events chan<- De
 	}
 
 	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxDeployEventSize)
 	for scanner.Scan() {
 		line := scanner.Text()
 
@@ -1429,8 +1493,39 @@ func (c *APIClient) DeleteBucket(id string) error {
 	return nil
 }
 
-// ConnectTerminalWebSocket opens a WebSocket connection to the instance terminal.
-func (c *APIClient) ConnectTerminalWebSocket(instanceID string) (*gorillaws.Conn, error) {
+// ConnectTerminalWebSocket opens a WebSocket connection to the instance
+// terminal. The terminal runs command instead of a login shell when set.
+// Connections with the same non-empty session ID reattach to the same
+// terminal on servers that keep it running between connections.
+func (c *APIClient) ConnectTerminalWebSocket(instanceID, command, session string) (*gorillaws.Conn, error) {
+	query := url.Values{}
+	if command != "" {
+		query.Set("command", command)
+	}
+	if session != "" {
+		query.Set("session", session)
+	}
+	return c.dialWebSocket("/instance/"+instanceID+"/terminal", query)
+}
+
+// ConnectExecWebSocket starts command on an instance over a bidirectional
+// WebSocket. Binary messages carry stdin and stdout; text messages carry JSON
+// control messages (stderr, eof, exit).
+func (c *APIClient) ConnectExecWebSocket(instanceName, command string) (*gorillaws.Conn, error) {
+	return c.dialWebSocket("/instance/"+instanceName+"/exec/ws", url.Values{"input": {command}})
+}
+
+// wsDialError is a WebSocket upgrade the server answered with an HTTP error.
+type wsDialError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *wsDialError) Error() string {
+	return fmt.Sprintf("websocket dial failed (%d): %s", e.StatusCode, e.Body)
+}
+
+func (c *APIClient) dialWebSocket(path string, query url.Values) (*gorillaws.Conn, error) {
 	u, err := url.Parse(c.BaseURL)
 	if err != nil {
 		return nil, err
@@ -1445,7 +1540,10 @@ func (c *APIClient) ConnectTerminalWebSocket(instanceID string) (*gorillaws.Conn
 	if strings.HasPrefix(u.Host, "api.") {
 		u.Host = "ws." + u.Host[len("api."):]
 	}
-	u.Path = strings.TrimRight(u.Path, "/") + "/instance/" + instanceID + "/terminal"
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
 
 	header := http.Header{}
 	if c.AuthToken != "" {
@@ -1461,7 +1559,7 @@ func (c *APIClient) ConnectTerminalWebSocket(instanceID string) (*gorillaws.Conn
 		if resp != nil {
 			body, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
-			return nil, fmt.Errorf("websocket dial failed (%d): %s", resp.StatusCode, string(body))
+			return nil, &wsDialError{StatusCode: resp.StatusCode, Body: string(body)}
 		}
 		return nil, fmt.Errorf("websocket dial failed: %w", err)
 	}
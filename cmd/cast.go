@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+// castHeader is the first line of an asciicast v2 recording.
+// See https://docs.asciinema.org/manual/asciicast/v2/
+type castHeader struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+// castRecorder writes a terminal session as asciicast v2: a header line,
+// then one [seconds, code, data] line per event, where code is "o" for
+// output, "i" for input and "r" for a resize to "COLSxROWS".
+type castRecorder struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	start   time.Time
+	input   bool              // record "i" events
+	partial map[string][]byte // incomplete UTF-8 held back per event code
+	err     error             // first write error
+}
+
+// newCastRecorder writes the header for a cols x rows terminal to w and
+// returns a recorder for the session's events. input enables recording
+// what the user types.
+func newCastRecorder(w io.Writer, cols, rows int, title string, input bool) (*castRecorder, error) {
+	r := &castRecorder{
+		w:       bufio.NewWriter(w),
+		start:   time.Now(),
+		input:   input,
+		partial: map[string][]byte{},
+	}
+	env := map[string]string{}
+	for _, k := range []string{"TERM", "SHELL"} {
+		if v := os.Getenv(k); v != "" {
+			env[k] = v
+		}
+	}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       env,
+	})
+	r.w.Write(append(header, '\n'))
+	if err := r.w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write recording: %v", err)
+	}
+	return r, nil
+}
+
+// output records data the terminal displayed.
+func (r *castRecorder) output(b []byte) {
+	r.event("o", b)
+}
+
+// inputData records data the user typed, if input recording is on.
+func (r *castRecorder) inputData(b []byte) {
+	if r.input {
+		r.event("i", b)
+	}
+}
+
+// resize records a change of terminal size.
+func (r *castRecorder) resize(cols, rows int) {
+	r.event("r", fmt.Appendf(nil, "%dx%d", cols, rows))
+}
+
+// event writes one event line. Data must be valid UTF-8 in the format, so
+// a multi-byte character split across calls is held until it completes.
+func (r *castRecorder) event(code string, b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	b = append(r.partial[code], b...)
+	b, r.partial[code] = splitUTF8(b)
+	if len(b) == 0 {
+		return
+	}
+	line, _ := json.Marshal([]any{
+		json.Number(fmt.Sprintf("%.6f", time.Since(r.start).Seconds())),
+		code,
+		string(b),
+	})
+	r.w.Write(append(line, '\n'))
+	r.err = r.w.Flush()
+}
+
+// Close flushes anything held back and reports the first write error.
+func (r *castRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	if r.err != nil {
+		return fmt.Errorf("failed to write recording: %v", r.err)
+	}
+	return nil
+}
+
+// splitUTF8 splits b before a trailing incomplete UTF-8 sequence, if any.
+func splitUTF8(b []byte) ([]byte, []byte) {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i], append([]byte(nil), b[i:]...)
+			}
+			break
+		}
+	}
+	return b, nil
+}
+
+// castEvent is one event line of a recording.
+type castEvent struct {
+	Time float64
+	Code string
+	Data string
+}
+
+// readCast reads an asciicast v2 recording.
+func readCast(r io.Reader) (*castHeader, []castEvent, error) {
+	dec := json.NewDecoder(r)
+	var header castHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, nil, fmt.Errorf("invalid recording header: %v", err)
+	}
+	if header.Version != 2 {
+		return nil, nil, fmt.Errorf("unsupported recording version %d (only asciicast v2 is supported)", header.Version)
+	}
+	var events []castEvent
+	for {
+		var raw []json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(raw) != 3 {
+			return nil, nil, fmt.Errorf("invalid recording event %d", len(events)+1)
+		}
+		var e castEvent
+		if json.Unmarshal(raw[0], &e.Time) != nil || json.Unmarshal(raw[1], &e.Code) != nil || json.Unmarshal(raw[2], &e.Data) != nil {
+			return nil, nil, fmt.Errorf("invalid recording event %d", len(events)+1)
+		}
+		events = append(events, e)
+	}
+	return &header, events, nil
+}
+
+// replayCast writes the output events to out at their recorded pace divided
+// by speed. Pauses are cut to idleLimit when it is set.
+func replayCast(events []castEvent, out io.Writer, speed float64, idleLimit time.Duration) {
+	var last float64
+	for _, e := range events {
+		if e.Code != "o" {
+			continue
+		}
+		pause := time.Duration((e.Time - last) * float64(time.Second))
+		last = e.Time
+		if idleLimit > 0 {
+			pause = min(pause, idleLimit)
+		}
+		if pause > 0 {
+			time.Sleep(time.Duration(float64(pause) / speed))
+		}
+		io.WriteString(out, e.Data)
+	}
+}
+
+func newReplayCmd() *cobra.Command {
+	var speed float64
+	var idleLimit time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "replay <file.cast>",
+		Short: "Play back a terminal session recorded with ssh --record",
+		Long: `Play back a terminal session recorded with "everywhere ssh --record".
+
+Recordings are asciicast v2, so they also play in asciinema. Output is
+written at the recorded pace; --speed 2 plays twice as fast and
+--idle-limit shortens long pauses.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if speed <= 0 {
+				return fmt.Errorf("--speed must be greater than 0")
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open recording: %v", err)
+			}
+			defer f.Close()
+			header, events, err := readCast(f)
+			if err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("idle-limit") && header.IdleTimeLimit > 0 {
+				idleLimit = time.Duration(header.IdleTimeLimit * float64(time.Second))
+			}
+			replayCast(events, os.Stdout, speed, idleLimit)
+			return nil
+		},
+	}
+
+	cmd.Flags().Float64Var(&speed, "speed", 1, "Playback speed multiplier")
+	cmd.Flags().DurationVar(&idleLimit, "idle-limit", 0, "Cut pauses longer than this (default: the recording's idle_time_limit, if any)")
+	return cmd
+}
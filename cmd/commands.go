@@ -4,18 +4,28 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"cmp"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"text/tabwriter"
 	"time"
+	"unicode/utf8"
 
 	"maps"
 
@@ -44,7 +54,7 @@ func NewRootCmd() *cobra.Command {
 	for _, c := range []*cobra.Command{
 		newInstancesCmd(), newDeployCmd(), newExecCmd(), newSSHCmd(),
 		newLogsCmd(), newPushCmd(), newRunCmd(), newRollbackCmd(),
-		newDeploysCmd(),
+		newDeploysCmd(), newOpenCmd(), newReplayCmd(),
 	} {
 		c.GroupID = "core"
 		root.AddCommand(c)
@@ -167,6 +177,7 @@ func showAuthStatus() error {
 		fmt.Fprintf(w, "Slug:\t%s\n", u.TenantSlug)
 	}
 	fmt.Fprintf(w, "API:\t%s\n", GetAPIEndpoint())
+	fmt.Fprintf(w, "App domain:\t%s\n", GetAppDomain())
 	fmt.Fprintf(w, "Authenticated:\t%t\n", true)
 	w.Flush()
 	return nil
@@ -263,7 +274,24 @@ func newAuthCmd() *cobra.Command {
 		},
 	}
 
-	cmd.AddCommand(newLoginCmd(), newLogoutCmd(), statusCmd, newAPIKeysCmd(), setEndpointCmd)
+	setAppDomainCmd := &cobra.Command{
+		Use:   "set-app-domain <domain>",
+		Short: "Set the domain app URLs are built on",
+		Long: `Set the domain used for app URLs the server does not report itself, e.g.
+apps.example.com for https://my-app.<slug>.apps.example.com. Pass "" to go back
+to the default, which is somewhere.dev for the hosted API and the API host
+without "api." for other endpoints. EVERYWHERE_APP_DOMAIN overrides it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := SetAppDomain(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("App domain set to %s\n", GetAppDomain())
+			return nil
+		},
+	}
+
+	cmd.AddCommand(newLoginCmd(), newLogoutCmd(), statusCmd, newAPIKeysCmd(), setEndpointCmd, setAppDomainCmd)
 	return cmd
 }
 
@@ -554,6 +582,99 @@ func newInstancePreviewURLCmd() *cobra.Command {
 	}
 }
 
+func newOpenCmd() *cobra.Command {
+	var port, urlPath string
+	var printOnly bool
+
+	cmd := &cobra.Command{
+		Use:   "open <app>",
+		Short: "Open an app or a preview port in the browser",
+		Long: `Open an app's public URL in the default browser. With --port, open the
+preview URL of that port instead. The URL is printed instead when no browser
+is available, or with --print.
+
+Examples:
+  everywhere open my-app
+  everywhere open my-app --path /admin
+  everywhere open my-app --port 5173`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			if err := validateInstanceName(name); err != nil {
+				return err
+			}
+			if err := requireAuth(); err != nil {
+				return err
+			}
+			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
+
+			var target string
+			if port != "" {
+				if _, err := strconv.Atoi(port); err != nil {
+					return fmt.Errorf("invalid port %q", port)
+				}
+				u, err := client.GetPortPreviewURL(name, port)
+				if err != nil {
+					return err
+				}
+				if strings.TrimSpace(u) == "" {
+					return fmt.Errorf("no preview URL for port %s on %s", port, name)
+				}
+				target = strings.TrimSpace(u)
+			} else {
+				target = newAppURLResolver(client).url(name)
+			}
+			target = withURLPath(target, urlPath)
+
+			if printOnly {
+				fmt.Println(target)
+				return nil
+			}
+			if err := openBrowser(target); err != nil {
+				fmt.Fprintln(os.Stderr, "Could not open a browser; open this URL instead:")
+				fmt.Println(target)
+				return nil
+			}
+			fmt.Printf("Opening %s\n", target)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&port, "port", "", "Open the preview URL of this app port")
+	cmd.Flags().StringVar(&urlPath, "path", "", "Path to append to the URL (e.g. /admin)")
+	cmd.Flags().BoolVar(&printOnly, "print", false, "Print the URL without opening a browser")
+	return cmd
+}
+
+// withURLPath appends urlPath to base's path. Query strings of both are
+// kept, so a signed preview URL stays valid.
+func withURLPath(base, urlPath string) string {
+	if urlPath == "" {
+		return base
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(urlPath, "/")
+	}
+	ref, err := url.Parse(urlPath)
+	if err != nil {
+		ref = &url.URL{Path: urlPath}
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + strings.TrimLeft(ref.Path, "/")
+	u.RawPath = ""
+	switch {
+	case ref.RawQuery == "":
+	case u.RawQuery == "":
+		u.RawQuery = ref.RawQuery
+	default:
+		u.RawQuery += "&" + ref.RawQuery
+	}
+	if ref.Fragment != "" {
+		u.Fragment = ref.Fragment
+	}
+	return u.String()
+}
+
 // ── Templates ─────────────────────────────────────────────────────────────────
 
 func listTemplates() error {
@@ -1373,13 +1494,40 @@ func newFilesUpdateCmd() *cobra.Command {
 }
 
 func newExecCmd() *cobra.Command {
-	var detach bool
+	var detach, interactive, tty, all, failFast bool
+	var timeout time.Duration
+	var envPairs, apps []string
+	var envFile, workdir, script, selector string
+	var parallel int
 
 	cmd := &cobra.Command{
-		Use:   "exec <app> <command>",
+		Use:   "exec <app> <command> | exec <app> --script <file> [-- args...]",
 		Short: "Execute commands in an app",
 		Long: `Execute a command in a running app.
 
+Output the command writes to stderr goes to local stderr, and exec exits
+with the command's exit code, so it can gate CI steps and && chains.
+
+Piped or redirected stdin is streamed to the command, ending with EOF, when
+the server supports it; otherwise exec warns and runs the command without
+input. Use -i to require it, or to forward stdin from a terminal too. Use -it
+for full-screen and interactive programs such as top, vim or a REPL: the
+command gets a TTY sized to your terminal.
+
+--env, --env-file and --workdir set the command's environment and directory
+for this invocation only. --timeout stops the command once it runs too long;
+exec then exits with status 124.
+
+--script runs a local script without pushing it first: it is uploaded to a
+temp file, run with the interpreter on its #! line (sh if there is none) and
+the remaining arguments, and deleted afterwards.
+
+--all, --apps and --selector run the command on many apps at once instead of
+one, at most --parallel at a time. Output lines are prefixed with the app
+name, and a table of exit codes and durations follows. --fail-fast stops the
+rest after the first failure. Piped stdin is not forwarded, as it cannot be
+split between apps.
+
 Use --detach to run a command in the background that survives after the
 session closes. Detached commands are tracked as jobs — use "everywhere jobs"
 to list, inspect, or cancel them.
@@ -1387,27 +1535,137 @@ to list, inspect, or cancel them.
 Examples:
   everywhere exec my-app "ls -la"
   everywhere exec my-app "npm start"
-  everywhere exec --detach my-app "python serve.py"`,
-		Args: cobra.ExactArgs(2),
+  everywhere exec --detach my-app "python serve.py"
+  cat dump.sql | everywhere exec my-app "psql"
+  everywhere exec -it my-app "python"
+  everywhere exec my-app "pytest" --workdir /app -e CI=1 --timeout 10m
+  everywhere exec my-app --script ./migrate.sh -- up --verbose
+  everywhere exec --all "df -h /"
+  everywhere exec --selector 'web-*' --fail-fast "cat /app/.env"`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			// The app comes from --all, --apps or --selector when fanning out
+			n := 2
+			if all || len(apps) > 0 || selector != "" {
+				n = 1
+			}
+			if script != "" {
+				return cobra.MinimumNArgs(n-1)(cmd, args)
+			}
+			return cobra.ExactArgs(n)(cmd, args)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := requireAuth(); err != nil {
 				return err
 			}
-			instance, command := args[0], args[1]
+			if timeout < 0 {
+				return fmt.Errorf("--timeout must not be negative")
+			}
+			fanOut := all || len(apps) > 0 || selector != ""
+			if all && (len(apps) > 0 || selector != "") {
+				return fmt.Errorf("--all cannot be combined with --apps or --selector")
+			}
+			if fanOut && (detach || interactive || tty) {
+				return fmt.Errorf("--detach, --interactive and --tty cannot be used with --all, --apps or --selector")
+			}
+			if fanOut && stdinIsPiped() {
+				fmt.Fprintln(os.Stderr, "Warning: stdin is not forwarded when running on several apps")
+			}
+			instance := ""
+			if !fanOut {
+				instance, args = args[0], args[1:]
+			}
+			env, err := mergeEnvSources(envPairs, envFile)
+			if err != nil {
+				return err
+			}
 			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
 
+			var s *execScript
+			command := ""
+			if script != "" {
+				data, err := os.ReadFile(script)
+				if err != nil {
+					return fmt.Errorf("failed to read script %s: %v", script, err)
+				}
+				s = newExecScript(script, string(data), shebangInterpreter(string(data)), args)
+				command = s.command
+			} else {
+				command = args[0]
+			}
+			opts := execOptions{Env: env, Workdir: workdir, Timeout: timeout}
+			if command, err = opts.wrap(command, tty); err != nil {
+				return err
+			}
+
+			if fanOut {
+				if all {
+					selector = "*"
+				}
+				targets, err := resolveTargets(client, apps, selector)
+				if err != nil {
+					return err
+				}
+				return execMany(client, targets, command, s, timeout, parallel, failFast)
+			}
+			if detach && (interactive || tty) {
+				return fmt.Errorf("--interactive and --tty cannot be used with --detach")
+			}
+			if tty && !term.IsTerminal(int(os.Stdin.Fd())) {
+				return fmt.Errorf("--tty requires stdin to be a terminal; drop -t to pipe input")
+			}
+			if s != nil {
+				if err := s.upload(client, instance); err != nil {
+					return err
+				}
+			}
 			if detach {
 				return execDetached(client, instance, command)
 			}
 
-			if err := client.StreamCommand(instance, command, os.Stdout); err != nil {
+			// The remote timeout reports first; the local one covers a
+			// server that never answers
+			ctx, cancel := context.WithCancel(context.Background())
+			if timeout > 0 {
+				ctx, cancel = context.WithTimeout(context.Background(), timeout+execTimeoutGrace)
+			}
+			defer cancel()
+
+			start := time.Now()
+			code, err := execAttached(ctx, client, instance, command, tty, detectStdin(interactive))
+			stopped := timeout > 0 && (ctx.Err() == context.DeadlineExceeded || (err == nil && timedOut(code, timeout, time.Since(start))))
+			if s != nil && (err != nil || stopped) {
+				// The script deletes itself when it exits; this covers
+				// losing the connection before it ran, and a kill at the
+				// timeout that skips its trap
+				s.remove(client, instance)
+			}
+			if stopped {
+				return &ExitError{Code: exitTimeout, Err: fmt.Errorf("command timed out after %s", timeout)}
+			}
+			if err != nil {
 				return err
 			}
+			if code != 0 {
+				// The command's own output already explains the failure
+				return &ExitError{Code: code}
+			}
 			return nil
 		},
 	}
 
 	cmd.Flags().BoolVarP(&detach, "detach", "d", false, "Run in background (survives session close)")
+	cmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Forward stdin to the command, failing if the server cannot (piped stdin is forwarded when possible)")
+	cmd.Flags().BoolVarP(&tty, "tty", "t", false, "Run the command in a TTY attached to your terminal")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Stop the command after this long and exit with status 124 (e.g. 30s, 10m)")
+	cmd.Flags().StringArrayVarP(&envPairs, "env", "e", nil, "Environment variable KEY=VALUE for the command (repeatable)")
+	cmd.Flags().StringVar(&envFile, "env-file", "", "Read environment variables for the command from file")
+	cmd.Flags().StringVarP(&workdir, "workdir", "w", "", "Directory to run the command in")
+	cmd.Flags().StringVar(&script, "script", "", "Upload and run this local script; arguments after the app are passed to it")
+	cmd.Flags().BoolVar(&all, "all", false, "Run on every app")
+	cmd.Flags().StringSliceVar(&apps, "apps", nil, "Run on these apps (comma-separated)")
+	cmd.Flags().StringVar(&selector, "selector", "", "Run on every app whose name matches this glob (e.g. 'web-*')")
+	cmd.Flags().IntVar(&parallel, "parallel", 4, "With several apps, how many to run on at once")
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "With several apps, stop after the first failure")
 	return cmd
 }
 
@@ -1431,30 +1689,53 @@ func execDetached(client *APIClient, instance, command string) error {
 }
 
 func newRunCmd() *cobra.Command {
-	var instance string
+	var instance, lang string
 
 	cmd := &cobra.Command{
-		Use:   "run <file|code>",
-		Short: "Run Python in an app",
-		Long:  "Run Python by providing a .py file path or an inline code string.",
-		Args:  cobra.ExactArgs(1),
+		Use:   "run <file|code> [-- args...]",
+		Short: "Run a file or code snippet in an app",
+		Long: `Run a script file or an inline code snippet in an app.
+
+The language comes from --lang, else the file extension, else its #! line:
+python, javascript, typescript, shell, bash, ruby or go. Inline code is Python
+unless --lang says otherwise.
+
+Python runs natively, even on a temporary app. Other languages, and Python
+given arguments or piped stdin, are uploaded and run through exec on the app
+named by --app, which needs the language's runtime installed. Arguments after
+-- are passed to the program, piped stdin is forwarded, and run exits with the
+program's exit code.
+
+Examples:
+  everywhere run "print(2 + 2)"
+  everywhere run --app my-app ./seed.js -- --count 100
+  everywhere run --app my-app --lang ts "console.log(process.version)"
+  cat data.csv | everywhere run --app my-app ./import.rb`,
+		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := requireAuth(); err != nil {
 				return err
 			}
 			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
 
-			input := args[0]
+			input, progArgs := args[0], args[1:]
 
 			if info, err := os.Stat(input); err == nil {
 				if info.IsDir() {
 					return fmt.Errorf("file not found or is a directory: %s", input)
 				}
-				return runFile(client, instance, input, "")
-			} else {
-				if strings.HasSuffix(strings.ToLower(input), ".py") || strings.Contains(input, string(os.PathSeparator)) {
-					return fmt.Errorf("file not found or is a directory: %s", input)
-				}
+				return runFile(client, instance, input, lang, progArgs)
+			} else if lang == "" && (hasRunExtension(input) || strings.Contains(input, string(os.PathSeparator))) {
+				// With --lang, anything that is not a file is code
+				return fmt.Errorf("file not found or is a directory: %s", input)
+			}
+
+			l, err := lookupRunLanguage(cmp.Or(lang, "python"))
+			if err != nil {
+				return err
+			}
+			if !l.Native || !runNatively(instance, progArgs) {
+				return runOnApp(client, instance, "snippet"+l.Extensions[0], input, l, progArgs)
 			}
 			output, err := client.RunPython(instance, input, "")
 			if err != nil {
@@ -1466,45 +1747,136 @@ func newRunCmd() *cobra.Command {
 	}
 
 	cmd.Flags().StringVarP(&instance, "app", "i", "auto", "App name (use 'auto' for a temporary app)")
+	cmd.Flags().StringVar(&lang, "lang", "", "Language of the file or code: "+runLanguageNames())
 	return cmd
 }
 
 // Deploy commands
 func newDeployCmd() *cobra.Command {
 	var repoURL, serviceCmd, source, port, entrypoint, provider string
-	var localPath string
+	var localPath, gitRef, message string
+	var output, transcript, selector string
+	var parallel int
 	var include []string
 	var envPairs []string
 	var envFile string
-	var follow bool
+	var follow, verbose, dereference, allowSecrets, allowDirty, noHooks, noLock bool
+	var lockWait, lockTTL time.Duration
+	var resolveLimits func() (archiveLimits, error)
+	var resolveHealth func(dir string) (*healthCheck, error)
 	cmd := &cobra.Command{
-		Use:   "deploy <app>",
-		Short: "Deploy code to an app",
+		Use:   "deploy <app> [app...]",
+		Short: "Deploy code to one or more apps",
 		Long: `Deploy code to an app from a local directory or git repository.
 
 When run from a project directory (contains package.json, go.mod, etc.),
 the current directory is deployed automatically — no flags needed.
 
+Use --ref to deploy a commit, branch or tag from the local git repository
+instead of the working tree. Only tracked files are sent (export-ignore in
+.gitattributes is honored), and the commit is recorded with the deploy.
+Deploys with --ref refuse to run with uncommitted changes unless --allow-dirty,
+and cannot be combined with --include, --dereference or a pre_build hook.
+
 Progress streams in real-time by default. Use --follow=false to get a workflow ID instead.
+Use -v to see the full agent loop grouped by iteration: each command with its
+complete output, diffs for written files, and entrypoint and port changes.
+Use --output ndjson to get every event, unfiltered, as one JSON object per line
+on stdout (progress messages go to stderr; not with -v), and --transcript
+to save the events to a file that 'everywhere deploy replay' can render later.
+With --transcript, Ctrl-C stops following the deploy and still saves the
+events received so far.
+
+Name several apps, or select them with --selector, to deploy the same code to
+all of them. The archive is built once; uploads and deploys run --parallel at
+a time with each line of output prefixed by the app name, followed by a
+pass/fail summary. Use {app} in --env-file and --transcript for per-app files.
+The local everywhere.json is only synced for single-app deploys without --ref.
+
+After a successful deploy, a health check probes the app when --health-path
+or a "health_check" object in everywhere.json sets a path, e.g.
+
+  "health_check": {"path": "/healthz", "status": 200, "body": "ok",
+                   "timeout": "5s", "retries": 5, "interval": "2s"}
+
+Flags override everywhere.json, which is only read for local deploys. If the
+check fails, the app is rolled back to the snapshot taken just before the
+deploy (unless --no-rollback) and the deploy fails. New apps and --repo deploys
+have no such snapshot and are left as they are.
+
+Local deploys run the hooks in everywhere.json, each a shell command or a list
+of them, from the project directory:
+
+  "hooks": {"pre_build": "npm run build", "post_push": "...",
+            "post_deploy": "npm run migrate"}
+
+pre_build runs once before archiving, post_push after the code reaches each
+app, and post_deploy after each followed deploy finishes (health check
+included). Hooks see EVERYWHERE_APP, EVERYWHERE_APPS, EVERYWHERE_APP_URL,
+EVERYWHERE_WORKFLOW_ID, EVERYWHERE_OUTCOME (success or failure),
+EVERYWHERE_GIT_COMMIT and EVERYWHERE_HOOK. A failing pre_build or post_push
+hook fails the deploy; a failing post_deploy hook only warns. Use --no-hooks
+to skip them.
+
+Local deploys take a lock on the app so two people cannot push at once. If
+someone else holds it, deploy shows who and fails, or waits up to --lock-wait.
+The lock is released when the deploy finishes and expires after --lock-ttl;
+'everywhere deploy unlock <app> --force' removes a lock left behind. The lock
+is best-effort: if the lock commands cannot run on the app at all, deploy
+warns and continues without it.
 
 Examples:
   everywhere deploy my-app                              # deploy current directory
   everywhere deploy my-app --local ./my-project         # deploy specific directory
-  everywhere deploy my-app --repo https://github.com/user/repo`,
-		Args: cobra.ExactArgs(1),
+  everywhere deploy my-app --ref v1.2.0                 # deploy a tag from the local repo
+  everywhere deploy my-app --repo https://github.com/user/repo
+  everywhere deploy my-app --transcript deploy.json     # save events for later
+  everywhere deploy replay deploy.json -v               # re-render a saved deploy
+  everywhere deploy --selector 'staging-*' --env-file .env.{app}`,
+		Args: cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := requireAuth(); err != nil {
 				return err
 			}
-			name := args[0]
-			if err := validateInstanceName(name); err != nil {
+			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
+			names, err := resolveTargets(client, args, selector)
+			if err != nil {
 				return err
 			}
-			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
+			urls := newAppURLResolver(client)
+			multi := len(names) > 1
+
+			// With --output ndjson, stdout carries only events; progress
+			// messages go to stderr
+			var out io.Writer = os.Stdout
+			switch output {
+			case "pretty":
+			case "ndjson":
+				if verbose {
+					return errVerboseNDJSON
+				}
+				out = os.Stderr
+			default:
+				return fmt.Errorf("unknown output format %q (use pretty or ndjson)", output)
+			}
+			if multi && transcript != "" && !strings.Contains(transcript, "{app}") {
+				return fmt.Errorf("--transcript needs {app} in the file name when deploying to several apps")
+			}
 
 			if repoURL != "" && localPath != "" {
 				return fmt.Errorf("cannot use both --repo and --local; choose one")
 			}
+			if repoURL != "" && gitRef != "" {
+				return fmt.Errorf("cannot use both --repo and --ref; --ref deploys from the local repository")
+			}
+			if gitRef != "" && (len(include) > 0 || dereference) {
+				return fmt.Errorf("--include and --dereference cannot be used with --ref; the commit's tracked files are deployed as they are")
+			}
+
+			// --ref always deploys from the local repository, project or not
+			if gitRef != "" && localPath == "" {
+				localPath = "."
+			}
 
 			// Auto-detect: if no --local and no --repo, check if cwd looks like a project
 			if localPath == "" && repoURL == "" {
@@ -1515,7 +1887,11 @@ Examples:
 				}
 			}
 
-			// For local deploys: ensure instance exists, push code, then deploy
+			// For local deploys, build the archive once for every app
+			var tmpTar string
+			var deployRev *gitRevision
+			var meta map[string]any
+			var hooks deployHooks
 			if localPath != "" {
 				info, err := os.Stat(localPath)
 				if err != nil {
@@ -1525,109 +1901,315 @@ Examples:
 					return fmt.Errorf("--local must point to a directory, got file: %s", localPath)
 				}
 
-				// Check if instance exists by searching the instance list
-				instances, _ := client.ListInstances()
-				var exists bool
-				for _, inst := range instances {
-					if inst.Name == name {
-						exists = true
-						break
-					}
-				}
-				if !exists {
-					_, createErr := client.CreateInstance(name, port, nil)
-					if createErr != nil {
-						return fmt.Errorf("failed to create app: %v", createErr)
+				if !noHooks {
+					manifest, err := loadProjectManifest(localPath)
+					if err != nil {
+						return err
 					}
+					hooks = manifest.Hooks
 				}
-				_ = client.StartInstance(name)
-				// Ensure instance is publicly accessible via *.somewhere.dev
-				_, _ = client.UpdateVisibility(name, true)
-				if !exists {
-					time.Sleep(2 * time.Second) // wait for new instance to be ready
+				if gitRef != "" && len(hooks.PreBuild) > 0 {
+					return fmt.Errorf("pre_build hooks cannot run with --ref, as their output would not be deployed; pass --no-hooks to skip them")
 				}
-
-				// Snapshot before pushing code so rollback restores clean state (skip for new instances)
-				if exists {
-					_ = client.CreateDeploySnapshot(name)
+				if len(hooks.PreBuild) > 0 {
+					single := ""
+					if !multi {
+						single = names[0]
+					}
+					if err := runHook("pre_build", hooks.PreBuild, localPath, hookEnv(names, single, "", "", "", nil), out); err != nil {
+						return err
+					}
 				}
 
-				tmpTar, err := createTarFromDir(localPath, include...)
+				// Archive first so symlink, secret and size checks fail before
+				// anything changes on the server
+				limits, err := resolveLimits()
 				if err != nil {
-					return fmt.Errorf("failed to archive directory: %v", err)
+					return err
 				}
-				defer os.Remove(tmpTar)
-				if fi, statErr := os.Stat(tmpTar); statErr == nil && fi.Size() > 0 {
-					sizeKB := (fi.Size() + 1023) / 1024 // round up
-					if sizeKB < 1024 {
-						fmt.Printf("Pushing code... (%d KB)\n", sizeKB)
+				archiveOpts := archiveOptions{Include: include, Dereference: dereference, AllowSecrets: allowSecrets, Limits: limits}
+				if gitRef != "" {
+					rev, err := resolveGitRevision(localPath, gitRef)
+					if err != nil {
+						return err
+					}
+					// The archive comes from the commit, so local edits are
+					// only refused here and never recorded as git_dirty
+					dirty, err := worktreeDirty(localPath)
+					if err != nil {
+						return err
+					}
+					if dirty && !allowDirty {
+						return fmt.Errorf("working tree has uncommitted changes; commit or stash them, or pass --allow-dirty")
+					}
+					tmpTar, err = createTarFromGitRevision(localPath, rev.Commit, archiveOpts)
+					if err != nil {
+						return fmt.Errorf("failed to archive %s: %v", gitRef, err)
+					}
+					deployRev = rev
+					if rev.Branch != "" {
+						fmt.Fprintf(out, "Deploying %s (%s on %s)\n", gitRef, shortSHA(rev.Commit), rev.Branch)
 					} else {
-						fmt.Printf("Pushing code... (%.1f MB)\n", float64(sizeKB)/1024)
+						fmt.Fprintf(out, "Deploying %s (%s)\n", gitRef, shortSHA(rev.Commit))
 					}
 				} else {
-					fmt.Println("Pushing code...")
-				}
-				if err := client.UploadArchive(name, tmpTar, "", "tar.gz"); err != nil {
-					return fmt.Errorf("failed to push code: %v", err)
+					tmpTar, err = createTarFromDir(localPath, archiveOpts)
+					if err != nil {
+						return fmt.Errorf("failed to archive directory: %v", err)
+					}
+					// Best effort: record the checked-out commit when the
+					// directory is a git repository
+					if rev, err := resolveGitRevision(localPath, "HEAD"); err == nil {
+						rev.Dirty, _ = worktreeDirty(localPath)
+						deployRev = rev
+					}
 				}
+				defer os.Remove(tmpTar)
 			}
+			meta = deployMetadata(localPath, deployRev, message, cmd.Root().Version)
 
-			data := map[string]any{}
-			if repoURL != "" {
-				data["repo_url"] = repoURL
-			}
-			if localPath != "" {
-				data["local"] = true
-			}
-			if serviceCmd != "" {
-				data["service_cmd"] = serviceCmd
-			}
-			if source != "" {
-				data["source"] = source
+			// Health checks come from flags or, for local deploys, the
+			// project's everywhere.json
+			hc, err := resolveHealth(localPath)
+			if err != nil {
+				return err
 			}
-			if port != "" {
-				data["port"] = port
+			if hc != nil && !follow {
+				fmt.Fprintln(os.Stderr, "Warning: health checks only run with --follow; skipping")
+				hc = nil
 			}
-			if entrypoint != "" {
-				data["entrypoint"] = entrypoint
+
+			// An --env-file containing {app} is read separately for each app
+			perAppEnv := strings.Contains(envFile, "{app}")
+			var sharedSecrets map[string]string
+			if !perAppEnv && (len(envPairs) > 0 || envFile != "") {
+				if sharedSecrets, err = mergeEnvSources(envPairs, envFile); err != nil {
+					return err
+				}
 			}
-			if provider != "" {
-				data["provider"] = provider
+
+			// Deploy locks held by this run, released when each deploy ends
+			var locksMu sync.Mutex
+			locks := map[string]*deployLock{}
+			// Pre-deploy snapshots taken by this run, which a failed health
+			// check rolls back to
+			snapshots := map[string]string{}
+			releaseLock := func(name string, out io.Writer) {
+				locksMu.Lock()
+				l := locks[name]
+				delete(locks, name)
+				locksMu.Unlock()
+				if l == nil {
+					return
+				}
+				if err := removeDeployLock(client, name, l); err != nil {
+					fmt.Fprintf(out, "Warning: failed to release deploy lock: %v\n", err)
+				}
 			}
-			if len(envPairs) > 0 || envFile != "" {
-				secrets, err := mergeEnvSources(envPairs, envFile)
-				if err != nil {
-					return err
+
+			// startDeploy pushes code to one app if needed and starts its
+			// deploy workflow
+			startDeploy := func(name string, out io.Writer) (wid string, err error) {
+				// Without --follow nothing waits for the deploy to finish,
+				// so the lock only covers the push
+				defer func() {
+					if err != nil || !follow {
+						releaseLock(name, out)
+					}
+				}()
+				if localPath != "" {
+					// Check if instance exists by searching the instance list
+					instances, _ := client.ListInstances()
+					var exists bool
+					for _, inst := range instances {
+						if inst.Name == name {
+							exists = true
+							break
+						}
+					}
+					if !exists {
+						_, createErr := client.CreateInstance(name, port, nil)
+						if createErr != nil {
+							return "", fmt.Errorf("failed to create app: %v", createErr)
+						}
+					}
+					_ = client.StartInstance(name)
+					// Ensure instance is publicly accessible at its app URL
+					if data, err := client.UpdateVisibility(name, true); err == nil {
+						urls.remember(name, data)
+					}
+					if !exists {
+						time.Sleep(2 * time.Second) // wait for new instance to be ready
+					}
+
+					if !noLock {
+						l, err := acquireDeployLock(client, name, lockTTL, lockWait, out)
+						var unavailable deployLockUnavailableError
+						switch {
+						case errors.As(err, &unavailable):
+							fmt.Fprintf(out, "Warning: deploying without a lock: %v\n", err)
+						case err != nil:
+							return "", err
+						default:
+							locksMu.Lock()
+							locks[name] = l
+							locksMu.Unlock()
+						}
+					}
+
+					// Snapshot before pushing code so rollback restores clean state (skip for new instances)
+					if exists {
+						// The lock stays held so no other deploy can start
+						// while the snapshot is taken. The snapshot records
+						// it, so rollback can remove the copy it restores
+						snapMeta := meta
+						locksMu.Lock()
+						held := locks[name]
+						locksMu.Unlock()
+						if held != nil {
+							snapMeta = maps.Clone(meta)
+							if snapMeta == nil {
+								snapMeta = map[string]any{}
+							}
+							snapMeta["deploy_lock"] = held.raw
+						}
+						snap, err := client.CreateDeploySnapshot(name, snapMeta)
+						if err == nil && snap == "" {
+							// Servers that do not name the snapshot list it
+							// last in the deploy history
+							snap = latestDeploySnapshot(client, name)
+						}
+						if snap != "" {
+							locksMu.Lock()
+							snapshots[name] = snap
+							locksMu.Unlock()
+						}
+					}
+
+					if fi, statErr := os.Stat(tmpTar); statErr == nil && fi.Size() > 0 {
+						sizeKB := (fi.Size() + 1023) / 1024 // round up
+						if sizeKB < 1024 {
+							fmt.Fprintf(out, "Pushing code... (%d KB)\n", sizeKB)
+						} else {
+							fmt.Fprintf(out, "Pushing code... (%.1f MB)\n", float64(sizeKB)/1024)
+						}
+					} else {
+						fmt.Fprintln(out, "Pushing code...")
+					}
+					if err := client.UploadArchive(name, tmpTar, "", "tar.gz"); err != nil {
+						return "", fmt.Errorf("failed to push code: %v", err)
+					}
+					if len(hooks.PostPush) > 0 {
+						if err := runHook("post_push", hooks.PostPush, localPath, hookEnv(names, name, urls.url(name), "", "", deployRev), out); err != nil {
+							return "", err
+						}
+					}
+				}
+
+				data := map[string]any{}
+				if repoURL != "" {
+					data["repo_url"] = repoURL
+				}
+				if localPath != "" {
+					data["local"] = true
+				}
+				maps.Copy(data, meta)
+				if serviceCmd != "" {
+					data["service_cmd"] = serviceCmd
+				}
+				if source != "" {
+					data["source"] = source
+				}
+				if port != "" {
+					data["port"] = port
+				}
+				if entrypoint != "" {
+					data["entrypoint"] = entrypoint
+				}
+				if provider != "" {
+					data["provider"] = provider
+				}
+				secrets := sharedSecrets
+				if perAppEnv {
+					var err error
+					secrets, err = mergeEnvSources(envPairs, strings.ReplaceAll(envFile, "{app}", name))
+					if err != nil {
+						return "", err
+					}
 				}
 				if len(secrets) > 0 {
 					data["secrets"] = secrets
 				}
+				wid, err = client.Deploy(name, data)
+				if err == nil && wid != "" {
+					if recErr := recordDeployWorkflow(name, wid); recErr != nil {
+						fmt.Fprintf(out, "Warning: failed to record deploy %s locally: %v\n", wid, recErr)
+					}
+				}
+				return wid, err
+			}
+
+			// finishDeploy runs once a followed deploy's stream ends: the
+			// health check, then the post-deploy hook with the outcome
+			finishDeploy := func(name, wid string, out io.Writer, err error) error {
+				defer releaseLock(name, out)
+				if err == nil {
+					locksMu.Lock()
+					snap := snapshots[name]
+					locksMu.Unlock()
+					err = verifyDeploy(client, name, urls.url(name), snap, hc, out)
+				}
+				// An interrupted deploy has no outcome yet
+				if len(hooks.PostDeploy) > 0 && !errors.Is(err, errDeployInterrupted) {
+					outcome := "success"
+					if err != nil {
+						outcome = "failure"
+					}
+					if hookErr := runHook("post_deploy", hooks.PostDeploy, localPath, hookEnv(names, name, urls.url(name), wid, outcome, deployRev), out); hookErr != nil {
+						fmt.Fprintf(out, "Warning: %v\n", hookErr)
+					}
+				}
+				return err
+			}
+
+			if multi {
+				return deployMany(client, urls, names, parallel, startDeploy, finishDeploy, follow, deployStreamOptions{
+					Output: output, Verbose: verbose, BaseDir: localPath, Transcript: transcript,
+				})
 			}
-			wid, err := client.Deploy(name, data)
+
+			name := names[0]
+			wid, err := startDeploy(name, out)
 			if err != nil {
 				return err
 			}
 
 			if !follow {
-				fmt.Printf("Deploy started. Workflow ID: %s\n", wid)
-				fmt.Printf("Check status: everywhere deploy status %s %s\n", name, wid)
+				if output == "ndjson" {
+					return json.NewEncoder(os.Stdout).Encode(map[string]string{"app": name, "workflow_id": wid})
+				}
+				fmt.Fprintf(out, "Deploy started. Workflow ID: %s\n", wid)
+				fmt.Fprintf(out, "Check status: everywhere deploy status %s %s\n", name, wid)
 				return nil
 			}
 
 			// Stream deploy events via SSE
-			err = streamDeployEvents(client, name, wid)
-			if err != nil {
+			err = streamDeployEvents(client, name, wid, deployStreamOptions{
+				Output: output, Verbose: verbose, BaseDir: localPath, Transcript: transcript, URL: urls.url(name),
+			})
+			if err := finishDeploy(name, wid, out, err); err != nil {
 				return err
 			}
 
-			// Sync manifest: always pull the latest from the container so local stays in sync
-			if localPath != "" {
+			// Sync manifest: always pull the latest from the container so local stays in sync.
+			// A --ref deploy never writes to the working tree it did not read from
+			if localPath != "" && gitRef == "" {
 				localManifest := filepath.Join(localPath, "everywhere.json")
 				_, hadLocal := os.Stat(localManifest)
 				if content, runErr := client.RunCommand(name, "cat /home/user/everywhere.json 2>/dev/null"); runErr == nil && strings.TrimSpace(content) != "" {
 					if writeErr := os.WriteFile(localManifest, []byte(strings.TrimSpace(content)+"\n"), 0644); writeErr == nil {
 						if os.IsNotExist(hadLocal) {
-							fmt.Printf("  Saved everywhere.json to %s\n", localPath)
+							fmt.Fprintf(out, "  Saved everywhere.json to %s\n", localPath)
 						}
 					}
 				}
@@ -1635,17 +2217,33 @@ Examples:
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&noHooks, "no-hooks", false, "Skip the hooks in everywhere.json")
+	cmd.Flags().BoolVar(&noLock, "no-lock", false, "Deploy without taking the app's deploy lock")
+	cmd.Flags().DurationVar(&lockWait, "lock-wait", 0, "If another deploy holds the lock, wait this long for it (default: fail at once)")
+	cmd.Flags().DurationVar(&lockTTL, "lock-ttl", defaultLockTTL, "Let others break this deploy's lock after this long")
+	cmd.Flags().StringVar(&selector, "selector", "", "Also deploy to every app whose name matches this glob (e.g. 'staging-*')")
+	cmd.Flags().IntVar(&parallel, "parallel", 4, "With several apps, how many to deploy at once")
 	cmd.Flags().StringVar(&repoURL, "repo", "", "Repository URL to clone and deploy")
 	cmd.Flags().StringVar(&localPath, "local", "", "Local directory to deploy (pushes code then deploys)")
+	cmd.Flags().StringVarP(&message, "message", "m", "", "Deploy message shown in 'everywhere deploys' (defaults to the commit subject)")
+	cmd.Flags().StringVar(&gitRef, "ref", "", "Deploy a commit, branch or tag from the local git repository")
+	cmd.Flags().BoolVar(&allowDirty, "allow-dirty", false, "Allow --ref deploys with uncommitted changes in the working tree")
 	cmd.Flags().StringArrayVar(&include, "include", nil, "Include patterns normally excluded by push (e.g. --include dist/)")
+	cmd.Flags().BoolVar(&dereference, "dereference", false, "Archive symlink targets instead of the links (allows links outside the directory)")
+	cmd.Flags().BoolVar(&allowSecrets, "allow-secrets", false, "Push even if files look like they contain credentials")
 	cmd.Flags().StringVar(&serviceCmd, "cmd", "", "Service command (e.g. \"node server.js\")")
 	cmd.Flags().StringVar(&source, "source", "", "App source/image hint")
 	cmd.Flags().StringVar(&port, "port", "", "Upstream service port")
 	cmd.Flags().StringVar(&entrypoint, "entrypoint", "", "Entrypoint override")
 	cmd.Flags().StringVar(&provider, "provider", "", "Provider hint (incus|runpod|nebius)")
 	cmd.Flags().StringArrayVarP(&envPairs, "env", "e", nil, "Environment variables KEY=VALUE (repeatable)")
-	cmd.Flags().StringVar(&envFile, "env-file", "", "Read environment variables from file (use - for stdin)")
+	cmd.Flags().StringVar(&envFile, "env-file", "", "Read environment variables from file (use - for stdin; {app} is replaced by each app's name)")
+	resolveLimits = addArchiveLimitFlags(cmd)
+	resolveHealth = addHealthCheckFlags(cmd)
 	cmd.Flags().BoolVarP(&follow, "follow", "f", true, "Stream deploy progress in real-time (default; use --follow=false to disable)")
+	cmd.Flags().StringVarP(&output, "output", "o", "pretty", "Progress format: pretty, or ndjson for every event as one JSON object per line")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show every agent iteration: full commands and output, file diffs and config changes")
+	cmd.Flags().StringVar(&transcript, "transcript", "", "Save every deploy event to a JSON file, replayable with 'everywhere deploy replay'")
 
 	// deploy status subcommand
 	statusCmd := &cobra.Command{
@@ -1666,189 +2264,567 @@ Examples:
 			return nil
 		},
 	}
-	cmd.AddCommand(statusCmd)
-	return cmd
-}
 
-// streamDeployEvents connects to the deploy SSE stream and renders progress.
-func streamDeployEvents(client *APIClient, name, wid string) error {
-	events := make(chan DeployEventStream, 64)
-	errCh := make(chan error, 1)
+	// deploy replay subcommand
+	var replayVerbose bool
+	replayCmd := &cobra.Command{
+		Use:   "replay <file>",
+		Short: "Render a saved deploy transcript",
+		Long: `Render a deploy transcript saved with --transcript, or NDJSON output saved
+from --output ndjson, as if the deploy were streaming now.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := loadDeployTranscript(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read transcript: %v", err)
+			}
+			url := t.URL
+			if url == "" {
+				url = buildAppURL(t.App, "", GetAppDomain())
+			}
+			r, err := newDeployRenderer(deployStreamOptions{Verbose: replayVerbose, URL: url}, os.Stdout)
+			if err != nil {
+				return err
+			}
+			events := make(chan DeployEventStream, len(t.Events))
+			for _, ev := range t.Events {
+				events <- ev
+			}
+			close(events)
+			_, err = renderDeployEvents(events, r, nil)
+			return err
+		},
+	}
+	replayCmd.Flags().BoolVarP(&replayVerbose, "verbose", "v", false, "Show every agent iteration with full commands, outputs and diffs")
+
+	// deploy attach subcommand
+	var attachOutput, attachTranscript string
+	var attachVerbose bool
+	attachCmd := &cobra.Command{
+		Use:   "attach <app> <workflow-id>",
+		Short: "Reattach to the progress stream of a running deploy",
+		Long: `Reattach to a deploy started with --follow=false, or one whose stream was
+interrupted, and render its progress until it finishes. Exits non-zero if the
+deploy fails.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireAuth(); err != nil {
+				return err
+			}
+			name, wid := args[0], args[1]
+			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
+			// A stream that ends without an outcome, e.g. because the
+			// deploy finished before we attached, falls back to polling
+			return streamDeployEvents(client, name, wid, deployStreamOptions{
+				Output: attachOutput, Verbose: attachVerbose, Transcript: attachTranscript,
+				URL: newAppURLResolver(client).url(name),
+			})
+		},
+	}
+	attachCmd.Flags().StringVarP(&attachOutput, "output", "o", "pretty", "Progress format: pretty, or ndjson for every event as one JSON object per line")
+	attachCmd.Flags().BoolVarP(&attachVerbose, "verbose", "v", false, "Show every agent iteration: full commands and output, file diffs and config changes")
+	attachCmd.Flags().StringVar(&attachTranscript, "transcript", "", "Save every deploy event to a JSON file, replayable with 'everywhere deploy replay'")
 
-	go func() {
-		errCh <- client.StreamDeployEvents(name, wid, events)
-	}()
+	// deploy wait subcommand
+	var waitTimeout time.Duration
+	waitCmd := &cobra.Command{
+		Use:   "wait <app> [workflow-id]",
+		Short: "Wait for a deploy to finish",
+		Long: `Block until a deploy finishes, without rendering its progress. Without a
+workflow ID, waits for the app's most recent in-progress deploy.
 
-	spinChars := []rune{'⠋', '⠙', '⠹', '⠸', '⠼', '⠴', '⠦', '⠧', '⠇', '⠏'}
-	spinIdx := 0
-	lastToolCall := ""
-	lastRunDetail := "" // remember the raw command from tool_call to show with timing
-	for ev := range events {
-		switch ev.Type {
-		case "step":
-			switch {
-			case ev.Message == "Thinking...":
-				continue
-			case strings.HasPrefix(ev.Message, "Initializing"),
-				strings.HasPrefix(ev.Message, "Checking instance"),
-				strings.HasPrefix(ev.Message, "Instance ready"),
-				strings.HasPrefix(ev.Message, "Snapshotting"),
-				strings.HasPrefix(ev.Message, "Checking health"),
-				strings.HasPrefix(ev.Message, "Manifest deploy failed"):
-				continue
-			case strings.HasPrefix(ev.Message, "Detected:"):
-				fmt.Printf("  %s\n", ev.Message)
-				continue
-			case strings.HasPrefix(ev.Message, "Found everywhere.json"):
-				fmt.Println("Using everywhere.json")
-				continue
-			case ev.Message == "Generated everywhere.json":
-				fmt.Println("✓ Generated everywhere.json")
-				continue
-			case ev.Message == "App output:" && ev.Detail != "":
-				// Show the last few meaningful lines from the crash log
-				lines := strings.Split(ev.Detail, "\n")
-				var errorLines []string
-				for i := len(lines) - 1; i >= 0 && len(errorLines) < 5; i-- {
-					line := strings.TrimSpace(lines[i])
-					if line == "" {
-						continue
-					}
-					errorLines = append([]string{line}, errorLines...)
-				}
-				if len(errorLines) > 0 {
-					fmt.Println("  App output:")
-					for _, line := range errorLines {
-						if len(line) > 120 {
-							line = line[:120] + "..."
-						}
-						fmt.Printf("    %s\n", line)
-					}
-				}
-				continue
-			default:
-				fmt.Printf("%c %s\n", spinChars[spinIdx%len(spinChars)], ev.Message)
-				spinIdx++
-			}
-		case "tool_call":
-			lastToolCall = ev.Tool
-			detail := ev.Detail
-			if len(detail) > 80 {
-				detail = detail[:80] + "..."
-			}
-			lastRunDetail = detail
-			switch ev.Tool {
-			case "run":
-				// Show semantic label if available, otherwise the command itself
-				if ev.Message != "" && ev.Message != detail {
-					fmt.Printf("%c %s\n", spinChars[spinIdx%len(spinChars)], ev.Message)
-				} else {
-					fmt.Printf("%c %s\n", spinChars[spinIdx%len(spinChars)], detail)
-				}
-			case "list_files", "get_env_info":
-				continue // suppress noisy discovery steps
-			case "update_entrypoint", "set_upstream_port":
-				continue // suppress config plumbing
-			case "write_file":
-				fmt.Printf("%c Writing %s\n", spinChars[spinIdx%len(spinChars)], detail)
-			default:
-				if detail != "" {
-					fmt.Printf("%c %s: %s\n", spinChars[spinIdx%len(spinChars)], ev.Message, detail)
-				} else {
-					fmt.Printf("%c %s\n", spinChars[spinIdx%len(spinChars)], ev.Message)
-				}
+Exit status is 0 if the deploy succeeded, 1 if it failed and 124 if --timeout
+expired first.
+
+Examples:
+  wid=$(everywhere deploy my-app --follow=false -o ndjson | jq -r .workflow_id)
+  everywhere deploy wait my-app "$wid" --timeout 15m`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireAuth(); err != nil {
+				return err
 			}
-			spinIdx++
-		case "tool_result":
-			switch {
-			case lastToolCall == "list_files" || lastToolCall == "get_env_info" ||
-				lastToolCall == "update_entrypoint" || lastToolCall == "set_upstream_port":
-				// Suppress results from discovery/config tools
-			case strings.HasPrefix(ev.Message, "OK"):
-				if ev.Tool == "run" {
-					// Extract timing if present: "OK (1.2s)"
-					timing := ""
-					if idx := strings.Index(ev.Message, "("); idx >= 0 {
-						timing = ev.Message[idx:]
-					}
-					// Show: "  npm install (4.1s)" — command + timing on result line
-					if timing != "" && lastRunDetail != "" {
-						fmt.Printf("  %s %s\n", lastRunDetail, timing)
-					} else if timing != "" {
-						fmt.Printf("  %s\n", timing)
-					} else if ev.Detail != "" {
-						// Fallback: show first line of command output
-						detail := ev.Detail
-						if idx := strings.Index(detail, "\n"); idx > 0 {
-							detail = detail[:idx]
-						}
-						if len(detail) > 100 {
-							detail = detail[:100] + "..."
-						}
-						if detail != "" {
-							fmt.Printf("  %s\n", detail)
-						}
-					}
+			name := args[0]
+			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
+			var wid string
+			if len(args) == 2 {
+				wid = args[1]
+			} else {
+				workflows, err := recentDeployWorkflows(client, name)
+				if err != nil {
+					return err
 				}
-			default:
-				// Failures — show most informative line of error output
-				fmt.Printf("  ✗ %s\n", ev.Message)
-				if ev.Detail != "" {
-					// Find first line that contains an actual error, skipping noise
-					var errorLine string
-					for line := range strings.SplitSeq(ev.Detail, "\n") {
-						line = strings.TrimSpace(line)
-						if line == "" {
-							continue
-						}
-						// Skip noise lines (Go module headers, npm warnings)
-						if strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "npm warn") {
-							continue
-						}
-						errorLine = line
+				for _, wf := range workflows {
+					if _, state := deployWorkflowState(wf); state == workflowRunning {
+						wid = workflowField(wf, "workflow_id")
 						break
 					}
-					if errorLine == "" {
-						// Fallback to first non-empty line
-						for line := range strings.SplitSeq(ev.Detail, "\n") {
-							if strings.TrimSpace(line) != "" {
-								errorLine = strings.TrimSpace(line)
-								break
-							}
+				}
+				if wid == "" {
+					return fmt.Errorf("no deploy in progress for %s; pass its workflow ID", name)
+				}
+				fmt.Fprintf(os.Stderr, "Waiting for deploy %s...\n", wid)
+			}
+			return waitForDeploy(client, name, wid, waitTimeout, os.Stdout)
+		},
+	}
+	waitCmd.Flags().DurationVar(&waitTimeout, "timeout", defaultDeployWaitTimeout, "Give up after this long (0 waits forever)")
+
+	// deploy list subcommand
+	listCmd := &cobra.Command{
+		Use:   "list <app>",
+		Short: "List in-progress and recent deploy workflows",
+		Long: `List the workflows of an app's recent deploys with their current status.
+Workflows come from the app's deploy history ('everywhere deploys') and from
+deploys started on this machine, which are recorded in ~/.everywhere.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireAuth(); err != nil {
+				return err
+			}
+			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
+			workflows, err := recentDeployWorkflows(client, args[0])
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "WORKFLOW ID\tSTATUS\tSTARTED\tDURATION")
+			for _, wf := range workflows {
+				status, state := deployWorkflowState(wf)
+				started, duration := "-", "-"
+				if start, err := time.Parse(time.RFC3339, workflowField(wf, "started_at", "start_time")); err == nil {
+					started = relativeTime(now, start)
+					end := now
+					if state != workflowRunning {
+						if closed, err := time.Parse(time.RFC3339, workflowField(wf, "closed_at", "close_time", "ended_at")); err == nil {
+							end = closed
 						}
 					}
-					if len(errorLine) > 120 {
-						errorLine = errorLine[:120] + "..."
-					}
-					if errorLine != "" {
-						fmt.Printf("    %s\n", errorLine)
-					}
+					duration = end.Sub(start).Round(time.Second).String()
 				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", workflowField(wf, "workflow_id"), orDash(status), started, duration)
 			}
-		case "done":
-			if strings.Contains(ev.Message, "rolled back") {
-				fmt.Printf("✗ %s\n", ev.Message)
-				fmt.Printf("  https://%s.somewhere.dev (previous version)\n", name)
-				return fmt.Errorf("deploy failed, rolled back")
+			return w.Flush()
+		},
+	}
+
+	// deploy unlock subcommand
+	var forceUnlock bool
+	unlockCmd := &cobra.Command{
+		Use:   "unlock <app>",
+		Short: "Release an app's deploy lock",
+		Long: `Release the deploy lock on an app. Without --force, only a lock taken by you
+on this machine is released; use --force to remove someone else's, e.g. one
+left behind by an interrupted deploy.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireAuth(); err != nil {
+				return err
 			}
-			if ev.Detail != "" {
-				fmt.Printf("✓ Deployed in %s\n", ev.Detail)
-			} else {
-				fmt.Println("✓ Deployed")
+			name := args[0]
+			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
+			l, err := readDeployLock(client, name)
+			if err != nil {
+				return err
+			}
+			if l == nil {
+				fmt.Printf("%s is not locked.\n", name)
+				return nil
+			}
+			host, _ := os.Hostname()
+			if !forceUnlock && (l.Owner != lockOwner() || l.Host != host) {
+				return fmt.Errorf("deploy lock is held by %s; use --force to remove it", l.describe(time.Now()))
 			}
-			fmt.Printf("  https://%s.somewhere.dev\n", name)
-		case "error":
-			fmt.Printf("✗ Deploy failed: %s\n", ev.Message)
-			return fmt.Errorf("deploy failed")
+			if err := removeDeployLock(client, name, l); err != nil {
+				return fmt.Errorf("failed to remove deploy lock: %v", err)
+			}
+			fmt.Printf("✓ Released deploy lock held by %s\n", l.describe(time.Now()))
+			return nil
+		},
+	}
+	unlockCmd.Flags().BoolVar(&forceUnlock, "force", false, "Remove the lock even if someone else holds it")
+
+	cmd.AddCommand(statusCmd, replayCmd, attachCmd, waitCmd, listCmd, unlockCmd)
+	return cmd
+}
+
+// deployMetadata describes a deploy for the deploy request and its snapshot:
+// the message, the git revision it was built from, who deployed it and the
+// CLI version. rev may be nil when the code does not come from a local
+// repository. An empty message defaults to the subject of a clean commit.
+func deployMetadata(dir string, rev *gitRevision, message, version string) map[string]any {
+	meta := map[string]any{}
+	if rev != nil {
+		meta["git_ref"] = rev.Ref
+		meta["git_commit"] = rev.Commit
+		meta["git_dirty"] = rev.Dirty
+		if rev.Branch != "" {
+			meta["git_branch"] = rev.Branch
+		}
+		if message == "" && !rev.Dirty {
+			message, _ = runGit(dir, "log", "-1", "--format=%s", rev.Commit)
 		}
 	}
+	if message != "" {
+		meta["message"] = message
+	}
+	author := GetUserEmail()
+	if author == "" && rev != nil {
+		author, _ = runGit(dir, "config", "user.email")
+	}
+	if author != "" {
+		meta["author"] = author
+	}
+	if version != "" {
+		meta["cli_version"] = version
+	}
+	return meta
+}
 
-	// Check if SSE stream had an error
-	if err := <-errCh; err != nil {
-		// If we already processed a done event, ignore connection close errors
-		return nil
+// deployStreamOptions controls how streamDeployEvents renders a deploy.
+type deployStreamOptions struct {
+	Output     string    // "pretty" (default) or "ndjson"
+	Verbose    bool      // show every iteration with full commands, outputs and diffs
+	BaseDir    string    // local project directory, for verbose write_file diffs
+	Transcript string    // file to save every received event to, if set
+	Out        io.Writer // defaults to stdout
+	App        string    // tags NDJSON events when deploying to several apps
+	URL        string    // app URL shown when the deploy finishes
+}
+
+// errDeployInterrupted is returned when Ctrl-C stops following a deploy,
+// which carries on on the server.
+var errDeployInterrupted = errors.New("interrupted; the deploy continues on the server")
+
+// streamDeployEvents connects to the deploy SSE stream and renders progress.
+func streamDeployEvents(client *APIClient, name, wid string, opts deployStreamOptions) error {
+	out := opts.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	r, err := newDeployRenderer(opts, out)
+	if err != nil {
+		return err
+	}
+
+	events := make(chan DeployEventStream, 64)
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- client.StreamDeployEvents(name, wid, events)
+	}()
+
+	var transcript *deployTranscript
+	var sig chan os.Signal
+	rendered := (<-chan DeployEventStream)(events)
+	interrupted := make(chan struct{})
+	renderDone := make(chan struct{})
+	if opts.Transcript != "" {
+		transcript = &deployTranscript{App: name, URL: opts.URL, WorkflowID: wid}
+		// Stop rendering on Ctrl-C so the transcript so far is still saved
+		sig = make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sig)
+		fwd := make(chan DeployEventStream)
+		go func() {
+			defer close(fwd)
+			for ev := range events {
+				select {
+				case fwd <- ev:
+				case <-sig:
+					close(interrupted)
+					return
+				case <-renderDone:
+					return
+				}
+			}
+		}()
+		rendered = fwd
+	}
+	finished, err := renderDeployEvents(rendered, r, transcript)
+	close(renderDone)
+	// Keep the stream from blocking if rendering stopped early
+	go func() {
+		for range events {
+		}
+	}()
+
+	var streamErr error
+	select {
+	case <-interrupted:
+		err = errDeployInterrupted
+	default:
+		// The stream has ended by now unless rendering failed, and then
+		// that failure is what gets reported
+		if err == nil {
+			streamErr = <-errCh
+		}
+	}
+	if sig != nil {
+		// Ctrl-C stops the polling below like any other command
+		signal.Stop(sig)
+	}
+	if transcript != nil {
+		transcript.Incomplete = !transcript.finished()
+		if saveErr := transcript.save(opts.Transcript); saveErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save transcript: %v\n", saveErr)
+		}
+	}
+	if err != nil || finished {
+		return err
+	}
+
+	// The stream dropped or the server closed it before the outcome
+	// arrived, so the workflow status has to tell how the deploy ended
+	progress := out
+	if opts.Output == "ndjson" {
+		progress = os.Stderr
+	}
+	if streamErr != nil {
+		fmt.Fprintf(progress, "Lost the deploy event stream (%v); waiting for the deploy to finish...\n", streamErr)
+	} else {
+		fmt.Fprintln(progress, "Deploy event stream ended early; waiting for the deploy to finish...")
+	}
+	err = waitForDeploy(client, name, wid, defaultDeployWaitTimeout, progress)
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		// Timed out with the deploy still running
+		return fmt.Errorf("%w; reattach with 'everywhere deploy attach %s %s'", err, name, wid)
+	}
+	return err
+}
+
+// deployMany starts deploys to several apps with bounded parallelism, streams
+// their events with per-app prefixes, calls finish as each stream ends and
+// prints a pass/fail matrix.
+func deployMany(client *APIClient, urls *appURLResolver, names []string, parallel int,
+	start func(name string, out io.Writer) (string, error),
+	finish func(name, wid string, out io.Writer, err error) error,
+	follow bool, opts deployStreamOptions) error {
+	var ndjsonMu sync.Mutex
+	results := runTargets(names, parallel, func(name string, out, errOut io.Writer) (string, error) {
+		appOpts := opts
+		appOpts.App = name
+		appOpts.Out = out
+		appOpts.Transcript = strings.ReplaceAll(opts.Transcript, "{app}", name)
+		progress := out
+		if opts.Output == "ndjson" {
+			// Events go to stdout unprefixed, tagged with the app instead
+			progress = errOut
+			events := &prefixWriter{w: os.Stdout, mu: &ndjsonMu}
+			defer events.Flush()
+			appOpts.Out = events
+		}
+
+		wid, err := start(name, progress)
+		if err != nil {
+			return "", err
+		}
+		if !follow {
+			fmt.Fprintf(progress, "Deploy started. Workflow ID: %s\n", wid)
+			return wid, nil
+		}
+		// After start, which learns the server's URL for the app
+		appOpts.URL = urls.url(name)
+		err = streamDeployEvents(client, name, wid, appOpts)
+		return wid, finish(name, wid, progress, err)
+	})
+	matrix := os.Stdout
+	if opts.Output == "ndjson" {
+		matrix = os.Stderr
+	}
+	return printTargetMatrix(matrix, results, "WORKFLOW")
+}
+
+// workflowState classifies a deploy workflow status.
+type workflowState int
+
+const (
+	workflowRunning workflowState = iota
+	workflowSucceeded
+	workflowFailed
+	workflowUnknown // the server reported no status
+)
+
+// deployWorkflowState reads the status of a deploy workflow from
+// DeployStatus and classifies it. Unrecognized statuses, such as "queued",
+// count as running.
+func deployWorkflowState(data map[string]any) (string, workflowState) {
+	status := strings.ToLower(workflowField(data, "status", "state"))
+	switch status {
+	case "":
+		return status, workflowUnknown
+	case "completed", "succeeded", "success", "done", "deployed":
+		return status, workflowSucceeded
+	case "failed", "error", "rolled_back", "canceled", "cancelled", "terminated", "timed_out":
+		return status, workflowFailed
+	}
+	return status, workflowRunning
+}
+
+// maxDeployWorkflows bounds how many workflows recentDeployWorkflows looks
+// up, and how many started deploys are recorded locally per app.
+const maxDeployWorkflows = 20
+
+// recentDeployWorkflows returns an app's recent deploy workflows, newest
+// first, each merged with its current DeployStatus. Workflows come from the
+// deploy history and from deploys started on this machine, since the history
+// does not record a workflow ID for every deploy.
+func recentDeployWorkflows(client *APIClient, name string) ([]map[string]any, error) {
+	deploys, err := client.DeployHistory(name)
+	if err != nil {
+		return nil, err
+	}
+	started := map[string]string{}
+	for _, d := range deploys {
+		if wid := deployField(d, "workflow_id"); wid != "" {
+			started[wid] = deployField(d, "created_at")
+		}
+	}
+	for _, rec := range loadDeployWorkflows()[name] {
+		if _, ok := started[rec.WorkflowID]; !ok {
+			started[rec.WorkflowID] = rec.StartedAt
+		}
+	}
+	if len(started) == 0 {
+		return nil, fmt.Errorf("no deploy workflows found for %s: its deploy history records no workflow IDs and none were started from this machine; pass the workflow ID", name)
+	}
+
+	workflows := make([]map[string]any, 0, len(started))
+	for wid, at := range started {
+		workflows = append(workflows, map[string]any{"workflow_id": wid, "started_at": at})
+	}
+	sort.SliceStable(workflows, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC3339, workflowField(workflows[i], "started_at"))
+		tj, _ := time.Parse(time.RFC3339, workflowField(workflows[j], "started_at"))
+		if ti.Equal(tj) {
+			return workflowField(workflows[i], "workflow_id") < workflowField(workflows[j], "workflow_id")
+		}
+		return ti.After(tj)
+	})
+	workflows = workflows[:min(len(workflows), maxDeployWorkflows)]
+
+	var wg sync.WaitGroup
+	for _, wf := range workflows {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := client.DeployStatus(name, workflowField(wf, "workflow_id")); err == nil {
+				maps.Copy(wf, data)
+			}
+		}()
+	}
+	wg.Wait()
+	return workflows, nil
+}
+
+// deployWorkflowRecord is a deploy started from this machine.
+type deployWorkflowRecord struct {
+	WorkflowID string `json:"workflow_id"`
+	StartedAt  string `json:"started_at"`
+}
+
+// deployWorkflowsMu serializes updates to the local record between the
+// parallel deploys of one command.
+var deployWorkflowsMu sync.Mutex
+
+func deployWorkflowsFilePath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".everywhere", "deploys.json")
+}
+
+// loadDeployWorkflows returns the locally recorded deploys by app. A missing
+// or unreadable record is treated as empty.
+func loadDeployWorkflows() map[string][]deployWorkflowRecord {
+	records := map[string][]deployWorkflowRecord{}
+	if data, err := os.ReadFile(deployWorkflowsFilePath()); err == nil {
+		_ = json.Unmarshal(data, &records)
+	}
+	return records
+}
+
+// recordDeployWorkflow remembers that a deploy of app name started as
+// workflow wid, keeping the newest maxDeployWorkflows per app.
+func recordDeployWorkflow(name, wid string) error {
+	deployWorkflowsMu.Lock()
+	defer deployWorkflowsMu.Unlock()
+	records := loadDeployWorkflows()
+	list := append(records[name], deployWorkflowRecord{WorkflowID: wid, StartedAt: time.Now().UTC().Format(time.RFC3339)})
+	records[name] = list[max(0, len(list)-maxDeployWorkflows):]
+
+	path := deployWorkflowsFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// workflowField returns the first of keys present in a workflow object, as
+// a string.
+func workflowField(data map[string]any, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := data[k]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// deployPollInterval is how often waitForDeploy checks workflow status.
+var deployPollInterval = 2 * time.Second
+
+// defaultDeployWaitTimeout bounds 'deploy wait' and the polling that replaces
+// a deploy event stream which ended before the outcome.
+const defaultDeployWaitTimeout = 30 * time.Minute
+
+// waitForDeploy polls a deploy workflow until it finishes, reporting success
+// to out. It returns nil on success, an error on failure and an ExitError
+// with exitTimeout when timeout (if non-zero) expires first. Errors fetching
+// the status are retried until then, as they are usually transient.
+func waitForDeploy(client *APIClient, name, wid string, timeout time.Duration, out io.Writer) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	var lastErr error
+	for {
+		data, err := client.DeployStatus(name, wid)
+		var status string
+		if err == nil {
+			var state workflowState
+			status, state = deployWorkflowState(data)
+			switch state {
+			case workflowSucceeded:
+				fmt.Fprintf(out, "✓ Deploy %s %s\n", wid, status)
+				return nil
+			case workflowFailed:
+				return fmt.Errorf("deploy %s %s", wid, status)
+			case workflowUnknown:
+				return fmt.Errorf("cannot tell whether deploy %s finished: the server reported no status", wid)
+			}
+		} else if lastErr == nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s; retrying\n", strings.TrimSpace(err.Error()))
+		}
+		lastErr = err
+
+		wait := deployPollInterval
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				if lastErr != nil {
+					return &ExitError{Code: exitTimeout, Err: fmt.Errorf("timed out after %s waiting for deploy %s: %v", timeout, wid, lastErr)}
+				}
+				return &ExitError{Code: exitTimeout, Err: fmt.Errorf("timed out after %s waiting for deploy %s (still %s)", timeout, wid, orDash(status))}
+			}
+			wait = min(wait, remaining)
+		}
+		time.Sleep(wait)
 	}
-	return nil
 }
 
 // Jobs commands
@@ -1874,62 +2850,231 @@ func listJobs(page, limit int) error {
 }
 
 func newRollbackCmd() *cobra.Command {
-	return &cobra.Command{
+	var to string
+	var dryRun, yes bool
+
+	cmd := &cobra.Command{
 		Use:   "rollback <app>",
 		Short: "Roll back to the previous deploy",
-		Args:  cobra.ExactArgs(1),
+		Long: `Restore an app to a pre-deploy snapshot.
+
+By default the most recent snapshot is restored. Use --to with a deploy number
+from 'everywhere deploys' or a snapshot name to pick another one, and --dry-run
+to see what would be restored without changing anything.
+
+Examples:
+  everywhere rollback my-app
+  everywhere rollback my-app --to 3
+  everywhere rollback my-app --to 2 --dry-run`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := requireAuth(); err != nil {
 				return err
 			}
+			name := args[0]
 			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
 
-			// Get deploy count before rollback for display
-			deploys, _ := client.DeployHistory(args[0])
-			deployNum := len(deploys)
-
-			result, err := client.Rollback(args[0])
-			if err != nil {
+			deploys, err := client.DeployHistory(name)
+			if err != nil && (to != "" || dryRun) {
 				return err
 			}
-			snap, _ := result["snapshot"].(string)
+			historyErr := err
+			idx := -1
+			if to != "" {
+				if idx, err = findDeploy(deploys, to); err != nil {
+					return err
+				}
+				// Without a name the server would restore its latest
+				// snapshot instead of the one asked for
+				if deploySnapshotName(deploys[idx]) == "" {
+					return fmt.Errorf("deploy #%d has no snapshot name, so it cannot be restored with --to", idx+1)
+				}
+			} else if len(deploys) > 0 {
+				idx = len(deploys) - 1
+			}
 
-			// Parse timestamp from snapshot name for relative time
-			relative := ""
-			if parts := strings.SplitN(snap, "-", 3); len(parts) == 3 {
-				if ts, err := fmt.Sscanf(parts[2], "%d", new(int64)); err == nil && ts == 1 {
-					var unix int64
-					fmt.Sscanf(parts[2], "%d", &unix)
-					ago := time.Since(time.Unix(unix, 0)).Truncate(time.Second)
-					if ago < time.Minute {
-						relative = fmt.Sprintf(" (%s ago)", ago)
-					} else if ago < time.Hour {
-						relative = fmt.Sprintf(" (%d minutes ago)", int(ago.Minutes()))
-					} else {
-						relative = fmt.Sprintf(" (%d hours ago)", int(ago.Hours()))
-					}
+			if dryRun {
+				if idx < 0 {
+					return fmt.Errorf("no deploy snapshots found for '%s'", name)
 				}
+				fmt.Printf("Would roll back '%s' to %s\n", name, describeDeploy(idx, deploys[idx]))
+				printDeployDetails(deploys[idx])
+				return nil
 			}
-			fmt.Printf("✓ Rolled back to deploy #%d%s\n", deployNum, relative)
 
-			// Wait for app to be ready after snapshot restore
-			name := args[0]
-			appURL := fmt.Sprintf("https://%s.somewhere.dev/", name)
-			for range 10 {
-				resp, err := http.Get(appURL)
-				if err == nil {
-					resp.Body.Close()
-					if resp.StatusCode == 200 {
-						fmt.Printf("  https://%s.somewhere.dev\n", name)
-						return nil
-					}
+			if !yes && term.IsTerminal(int(syscall.Stdin)) {
+				target := "the previous deploy"
+				if idx >= 0 {
+					target = describeDeploy(idx, deploys[idx])
+				}
+				fmt.Printf("Roll back '%s' to %s? (y/N): ", name, target)
+				reader := bufio.NewReader(os.Stdin)
+				resp, err := reader.ReadString('\n')
+				if err != nil {
+					return err
 				}
-				time.Sleep(time.Second)
+				switch strings.ToLower(strings.TrimSpace(resp)) {
+				case "y", "yes":
+				default:
+					fmt.Println("Rollback cancelled")
+					return nil
+				}
+			}
+
+			snapshot := ""
+			if to != "" {
+				snapshot = deploySnapshotName(deploys[idx])
+			}
+			result, err := client.Rollback(name, snapshot)
+			if err != nil {
+				return err
+			}
+
+			// Report what the server actually restored, not what we asked for
+			restored, _ := result["snapshot"].(string)
+			restoredIdx := -1
+			for i, d := range deploys {
+				if restored != "" && deploySnapshotName(d) == restored {
+					restoredIdx = i
+					break
+				}
+			}
+			switch {
+			case restoredIdx >= 0:
+				fmt.Printf("✓ Rolled back to %s\n", describeDeploy(restoredIdx, deploys[restoredIdx]))
+				printDeployDetails(deploys[restoredIdx])
+			case restored != "":
+				fmt.Printf("✓ Rolled back to snapshot %s%s\n", restored, snapshotAge(restored))
+			default:
+				fmt.Println("✓ Rolled back")
+			}
+			// Snapshots taken by a locked deploy contain its lock file,
+			// which the restore brings back long after that deploy ended.
+			// Whichever snapshot was restored, its lock is in the history
+			if historyErr != nil {
+				deploys, _ = client.DeployHistory(name)
+			}
+			if err := removeRestoredDeployLock(client, name, deploys); err != nil {
+				fmt.Printf("Warning: failed to remove the deploy lock restored with the snapshot: %v\n", err)
+			}
+
+			// Wait for the app to be ready after the snapshot restore. The
+			// current directory's everywhere.json may belong to another app,
+			// so only the app's root is probed
+			hc := &healthCheck{
+				Path:     "/",
+				Status:   http.StatusOK,
+				Timeout:  defaultHealthTimeout,
+				Retries:  9,
+				Interval: rollbackReadyInterval,
+			}
+			url := newAppURLResolver(client).url(name)
+			if _, ok := runHealthCheck(url, hc, os.Stdout); !ok {
+				fmt.Printf("  %s (may take a moment to start)\n", url)
+				return nil
 			}
-			fmt.Printf("  https://%s.somewhere.dev (may take a moment to start)\n", name)
+			fmt.Printf("  %s\n", url)
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVar(&to, "to", "", "Deploy number (see 'everywhere deploys') or snapshot name to restore")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show which snapshot would be restored without rolling back")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
+	return cmd
+}
+
+// rollbackReadyInterval is how often rollback checks whether the restored
+// app is ready.
+var rollbackReadyInterval = time.Second
+
+// findDeploy resolves a deploy number ("3" or "#3", as shown by 'everywhere
+// deploys') or a snapshot name to an index into deploys.
+func findDeploy(deploys []map[string]any, ref string) (int, error) {
+	for i, d := range deploys {
+		if deploySnapshotName(d) == ref {
+			return i, nil
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(ref, "#")); err == nil {
+		if n < 1 || n > len(deploys) {
+			return -1, fmt.Errorf("deploy #%d not found (have %d deploy snapshots)", n, len(deploys))
+		}
+		return n - 1, nil
+	}
+	return -1, fmt.Errorf("no deploy snapshot named %q; see 'everywhere deploys'", ref)
+}
+
+// latestDeploySnapshot returns the name of the newest snapshot in name's
+// deploy history, or "" if there is none.
+func latestDeploySnapshot(client *APIClient, name string) string {
+	deploys, err := client.DeployHistory(name)
+	if err != nil {
+		return ""
+	}
+	for i := len(deploys) - 1; i >= 0; i-- {
+		if snap := deploySnapshotName(deploys[i]); snap != "" {
+			return snap
+		}
+	}
+	return ""
+}
+
+// deploySnapshotName returns the snapshot name of a deploy history entry.
+func deploySnapshotName(d map[string]any) string {
+	if s := deployField(d, "snapshot"); s != "" {
+		return s
+	}
+	return deployField(d, "name")
+}
+
+// describeDeploy renders "deploy #N (snapshot, 2 hours ago)" for a history entry.
+func describeDeploy(idx int, d map[string]any) string {
+	var parts []string
+	if snap := deploySnapshotName(d); snap != "" {
+		parts = append(parts, snap)
+	}
+	if t, err := time.Parse(time.RFC3339, deployField(d, "created_at")); err == nil {
+		parts = append(parts, relativeTime(time.Now(), t))
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("deploy #%d", idx+1)
+	}
+	return fmt.Sprintf("deploy #%d (%s)", idx+1, strings.Join(parts, ", "))
+}
+
+// printDeployDetails prints the recorded metadata of a deploy history entry.
+func printDeployDetails(d map[string]any) {
+	if sha := deployField(d, "git_commit"); sha != "" {
+		if branch := deployField(d, "git_branch"); branch != "" {
+			sha += " on " + branch
+		}
+		if deployField(d, "git_dirty") == "true" {
+			sha += " (dirty)"
+		}
+		fmt.Printf("  commit:  %s\n", sha)
+	}
+	if author := deployField(d, "author"); author != "" {
+		fmt.Printf("  author:  %s\n", author)
+	}
+	if msg := deployField(d, "message"); msg != "" {
+		first, _, _ := strings.Cut(msg, "\n")
+		fmt.Printf("  message: %s\n", first)
+	}
+}
+
+// snapshotAge derives " (2 hours ago)" from a snapshot name ending in a unix
+// timestamp, or "" when the name carries no timestamp.
+func snapshotAge(snap string) string {
+	i := strings.LastIndex(snap, "-")
+	if i < 0 {
+		return ""
+	}
+	unix, err := strconv.ParseInt(snap[i+1:], 10, 64)
+	if err != nil {
+		return ""
+	}
+	return " (" + relativeTime(time.Now(), time.Unix(unix, 0)) + ")"
 }
 
 func newDeploysCmd() *cobra.Command {
@@ -1947,6 +3092,8 @@ func newDeploysCmd() *cobra.Command {
 			return nil
 		}
 		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DEPLOY\tAGE\tSHA\tAUTHOR\tMESSAGE")
 		for i := len(deploys) - 1; i >= 0; i-- {
 			d := deploys[i]
 			created, _ := d["created_at"].(string)
@@ -1958,9 +3105,16 @@ func newDeploysCmd() *cobra.Command {
 			if t, parseErr := time.Parse(time.RFC3339, created); parseErr == nil {
 				timeStr = relativeTime(now, t)
 			}
-			fmt.Printf("#%d  %s%s\n", i+1, timeStr, label)
+			sha := shortSHA(deployField(d, "git_commit"))
+			if sha != "" && deployField(d, "git_dirty") == "true" {
+				sha += "-dirty"
+			}
+			msg, _, _ := strings.Cut(deployField(d, "message"), "\n")
+			msg = truncateRunes(msg, 60)
+			fmt.Fprintf(w, "#%d\t%s%s\t%s\t%s\t%s\n", i+1, timeStr, label,
+				orDash(sha), orDash(deployField(d, "author")), orDash(msg))
 		}
-		return nil
+		return w.Flush()
 	}
 
 	cmd := &cobra.Command{
@@ -1975,6 +3129,42 @@ func newDeploysCmd() *cobra.Command {
 	return cmd
 }
 
+// deployField returns a deploy history field as a string, looking in the
+// entry itself and then in its nested "metadata" object.
+func deployField(d map[string]any, key string) string {
+	v, ok := d[key]
+	if !ok {
+		if m, isMap := d["metadata"].(map[string]any); isMap {
+			v, ok = m[key]
+		}
+	}
+	if !ok || v == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(v))
+}
+
+// orDash returns s, or "-" when s is empty, for table cells.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// truncateRunes shortens s to at most n runes, ending in "..." when cut,
+// without splitting a multi-byte character. Limits too small for "..."
+// cut without it.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n < 3 {
+		return string([]rune(s)[:max(n, 0)])
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
+
 // relativeTime returns a human-readable relative time string.
 func relativeTime(now, t time.Time) string {
 	d := now.Sub(t)
@@ -2124,28 +3314,22 @@ func newJobsCmd() *cobra.Command {
 	return cmd
 }
 
-func runFile(client *APIClient, instance, filePath, forceLang string) error {
+func runFile(client *APIClient, instance, filePath, forceLang string, args []string) error {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read file %s: %v", filePath, err)
 	}
 	code := string(data)
 
-	lang := forceLang
-	if lang == "" {
-		ext := strings.ToLower(filepath.Ext(filePath))
-		if ext == ".py" {
-			lang = "python"
-		} else {
-			return fmt.Errorf("only Python .py files are supported. File extension '%s' is not supported", ext)
-		}
+	lang, err := detectRunLanguage(filePath, code, forceLang)
+	if err != nil {
+		return err
 	}
-
-	if lang != "python" {
-		return fmt.Errorf("only Python files are supported. Provide a .py file")
+	if !lang.Native || !runNatively(instance, args) {
+		return runOnApp(client, instance, filePath, code, lang, args)
 	}
 
-	fmt.Printf("Running %s as Python in app '%s'...\n", filePath, instance)
+	fmt.Printf("Running %s as %s in app '%s'...\n", filePath, lang.Display, instance)
 
 	output, err := client.RunPython(instance, code, "")
 	if err != nil {
@@ -2156,10 +3340,38 @@ func runFile(client *APIClient, instance, filePath, forceLang string) error {
 	return nil
 }
 
+// archiveOptions controls how createTarFromDir builds an upload archive.
+type archiveOptions struct {
+	// Include removes matching patterns from the default ignore list (e.g. "dist/").
+	Include []string
+	// Dereference archives the targets of symlinks instead of the links
+	// themselves, including links that point outside the directory.
+	Dereference bool
+	// AllowSecrets reports likely credentials as a warning instead of
+	// failing the archive.
+	AllowSecrets bool
+	// Limits caps file sizes and counts; see limits.go.
+	Limits archiveLimits
+}
+
 // createTarFromDir creates a gzipped tar archive of a directory, respecting
 // .gitignore and default ignore rules.
-// includeOverrides removes matching patterns from the default ignore list (e.g. "dist/").
-func createTarFromDir(dir string, includeOverrides ...string) (string, error) {
+//
+// Symlinks that resolve inside dir are stored as links (absolute targets are
+// rewritten relative to the link); links that escape dir fail the archive
+// unless opts.Dereference is set. Sockets, FIFOs and device files are skipped
+// with a warning. Permission bits are preserved, local ownership is not.
+//
+// Files are scanned for likely credentials (see secrets.go); any findings
+// fail the archive unless opts.AllowSecrets is set. Exceeding opts.Limits
+// fails the archive with a report of the largest paths, and getting close
+// to a limit prints the same report as a warning.
+func createTarFromDir(dir string, opts archiveOptions) (string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
 	f, err := os.CreateTemp("", "everywhere-upload-*.tar.gz")
 	if err != nil {
 		return "", fmt.Errorf("create temp archive: %v", err)
@@ -2169,64 +3381,275 @@ func createTarFromDir(dir string, includeOverrides ...string) (string, error) {
 	gw := gzip.NewWriter(f)
 	tw := tar.NewWriter(gw)
 
-	patterns := loadIgnorePatterns(dir, includeOverrides)
+	b := &tarBuilder{
+		tw:       tw,
+		roots:    []string{root},
+		patterns: loadIgnorePatterns(root, opts.Include),
+		opts:     opts,
+		visited:  map[string]bool{},
+		secrets:  newSecretScanner(root),
+		budget:   newArchiveBudget(opts.Limits),
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		b.visited[real] = true
+		if real != root {
+			b.roots = append(b.roots, real)
+		}
+	}
+
+	err = b.walkDir(root, "")
+	if err == nil {
+		err = b.check(dir)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// check reports problems collected while building the archive: escaping
+// symlinks, exceeded size limits and likely secrets. Warnings for secrets
+// allowed by opts.AllowSecrets and for nearly exhausted limits go to stderr.
+func (b *tarBuilder) check(dir string) error {
+	if len(b.escaping) > 0 {
+		return fmt.Errorf("symlinks point outside %s (use --dereference to archive their targets):\n  %s",
+			dir, strings.Join(b.escaping, "\n  "))
+	}
+	if b.budget.exceeded() {
+		return b.budget.err()
+	}
+	if err := b.secrets.check(b.opts.AllowSecrets); err != nil {
+		return err
+	}
+	b.budget.warn()
+	return nil
+}
+
+// tarBuilder walks a directory tree into a tar writer.
+type tarBuilder struct {
+	tw       *tar.Writer
+	roots    []string // archive root, plus its resolved path when it differs
+	patterns []string
+	opts     archiveOptions
+	visited  map[string]bool // resolved directories on the current path, for cycle detection
+	escaping []string
+	secrets  *secretScanner
+	budget   *archiveBudget
+}
 
-	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+func (b *tarBuilder) walkDir(diskDir, relDir string) error {
+	entries, err := os.ReadDir(diskDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		diskPath := filepath.Join(diskDir, e.Name())
+		rel := e.Name()
+		if relDir != "" {
+			rel = relDir + "/" + e.Name()
+		}
+		info, err := os.Lstat(diskPath)
 		if err != nil {
 			return err
 		}
-
-		rel, err := filepath.Rel(dir, path)
-		if err != nil {
+		if err := b.add(diskPath, rel, info); err != nil {
 			return err
 		}
-		if rel == "." {
+	}
+	return nil
+}
+
+func (b *tarBuilder) add(diskPath, rel string, info os.FileInfo) error {
+	mode := info.Mode()
+	if mode&os.ModeSymlink != 0 {
+		// Ignored links are skipped before any symlink policy applies. A
+		// link to a directory matches dir-only patterns ("foo/") as the
+		// directory would
+		target, statErr := os.Stat(diskPath)
+		if shouldIgnore(rel, statErr == nil && target.IsDir(), b.patterns) {
 			return nil
 		}
-
-		relSlash := strings.ReplaceAll(rel, string(filepath.Separator), "/")
-		if shouldIgnore(relSlash, info.IsDir(), patterns) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
+		if !b.opts.Dereference {
+			return b.addSymlink(diskPath, rel, info)
+		}
+		if statErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: broken symlink\n", rel)
 			return nil
 		}
-
-		header, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
+		info, mode = target, target.Mode()
+		// The target's own name can give a secret away too (config -> .env)
+		if real, err := filepath.EvalSymlinks(diskPath); err == nil && mode.IsRegular() &&
+			filepath.Base(real) != filepath.Base(rel) {
+			b.secrets.scanName(rel, filepath.Base(real))
 		}
-		header.Name = relSlash
+	}
 
-		if err := tw.WriteHeader(header); err != nil {
+	if shouldIgnore(rel, mode.IsDir(), b.patterns) {
+		return nil
+	}
+
+	switch {
+	case mode.IsDir():
+		real, err := filepath.EvalSymlinks(diskPath)
+		if err != nil {
 			return err
 		}
-
-		if info.IsDir() {
+		if b.visited[real] {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: symlink loop\n", rel)
 			return nil
 		}
+		b.visited[real] = true
+		defer delete(b.visited, real)
 
-		in, err := os.Open(path)
+		header, err := archiveHeader(info, rel)
 		if err != nil {
 			return err
 		}
-		defer in.Close()
+		if err := b.tw.WriteHeader(header); err != nil {
+			return err
+		}
+		return b.walkDir(diskPath, rel)
+	case mode.IsRegular():
+		return b.addFile(diskPath, rel, info)
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s: %s\n", rel, describeSpecialFile(mode))
+		return nil
+	}
+}
 
-		_, err = io.Copy(tw, in)
+func (b *tarBuilder) addFile(diskPath, rel string, info os.FileInfo) error {
+	if b.budget.add(rel, info.Size()) {
+		b.secrets.scanName(rel, filepath.Base(rel))
+		return nil // over budget; keep walking only to report every offender
+	}
+	header, err := archiveHeader(info, rel)
+	if err != nil {
 		return err
-	})
+	}
+	in, err := os.Open(diskPath)
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer in.Close()
 
-	if err := tw.Close(); err != nil {
-		return "", err
+	if runtime.GOOS == "windows" && hasShebang(in) {
+		// Windows has no executable bit; treat scripts as executable so
+		// they still run after extraction in the app.
+		header.Mode |= 0o111
 	}
-	if err := gw.Close(); err != nil {
-		return "", err
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := b.secrets.scan(rel, in, info.Size()); err != nil {
+		return err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
 	}
 
-	return f.Name(), nil
+	if err := b.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(b.tw, in)
+	return err
+}
+
+func (b *tarBuilder) addSymlink(diskPath, rel string, info os.FileInfo) error {
+	target, err := os.Readlink(diskPath)
+	if err != nil {
+		return err
+	}
+	linkname, ok := b.linkTarget(diskPath, target)
+	if !ok {
+		b.escaping = append(b.escaping, rel+" -> "+target)
+		return nil
+	}
+	header, err := archiveHeader(info, rel)
+	if err != nil {
+		return err
+	}
+	header.Linkname = linkname
+	return b.tw.WriteHeader(header)
+}
+
+// linkTarget maps a symlink target onto the archive. Relative targets are
+// kept as-is; absolute targets inside the root are rewritten relative to the
+// link so they still resolve after extraction at a different path. ok is
+// false when the target escapes the root.
+func (b *tarBuilder) linkTarget(linkPath, target string) (string, bool) {
+	abs := target
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(filepath.Dir(linkPath), abs)
+	}
+	abs = filepath.Clean(abs)
+
+	for _, root := range b.roots {
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if !filepath.IsAbs(target) {
+			return filepath.ToSlash(target), true
+		}
+		linkRel, err := filepath.Rel(b.roots[0], filepath.Dir(linkPath))
+		if err != nil {
+			return "", false
+		}
+		fromLink, err := filepath.Rel(filepath.Join(root, linkRel), abs)
+		if err != nil {
+			return "", false
+		}
+		return filepath.ToSlash(fromLink), true
+	}
+	return "", false
+}
+
+// archiveHeader builds a tar header for name, keeping permission bits
+// (including setuid/setgid/sticky) and mtime but dropping local ownership,
+// which means nothing inside the app container.
+func archiveHeader(info os.FileInfo, name string) (*tar.Header, error) {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	return header, nil
+}
+
+// hasShebang reports whether r starts with "#!".
+func hasShebang(r io.Reader) bool {
+	buf := make([]byte, 2)
+	n, _ := io.ReadFull(r, buf)
+	return n == 2 && string(buf) == "#!"
+}
+
+func describeSpecialFile(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "device file"
+	default:
+		return "unsupported file type"
+	}
 }
 
 // loadIgnorePatterns builds a list of ignore rules from .gitignore and defaults.
@@ -2329,11 +3752,9 @@ func loadIgnorePatterns(root string, includeOverrides []string) []string {
 
 // shouldIgnore checks if rel path should be ignored according to patterns
 func shouldIgnore(rel string, isDir bool, patterns []string) bool {
-	// Ensure directory paths end with '/'
-	path := rel
-	if isDir && !strings.HasSuffix(path, "/") {
-		path += "/"
-	}
+	// Dir-only patterns are told apart by isDir, so paths are matched
+	// without a trailing '/'
+	path := strings.TrimSuffix(rel, "/")
 	base := filepath.Base(rel)
 
 	for _, raw := range patterns {
@@ -2391,6 +3812,8 @@ func globMatch(pattern, name string) bool {
 func newPushCmd() *cobra.Command {
 	var targetPath string
 	var include []string
+	var dereference, allowSecrets bool
+	var resolveLimits func() (archiveLimits, error)
 
 	cmd := &cobra.Command{
 		Use:   "push <app> [path]",
@@ -2401,7 +3824,24 @@ By default, the following patterns are excluded: .git/, node_modules/, dist/,
 build/, .cache/, vendor/, .DS_Store, *.pyc, __pycache__/
 Plus any patterns from .gitignore.
 
-Use --include to override specific default exclusions (e.g. --include dist/).`,
+Use --include to override specific default exclusions (e.g. --include dist/).
+
+Symlinks that stay inside the directory are uploaded as links. Links that
+point outside it are rejected; use --dereference to upload their targets'
+contents instead. Sockets, FIFOs and device files are skipped with a warning.
+File permissions are preserved, so scripts stay executable.
+
+Files that look like credentials (.env, id_rsa, *.pem, AWS keys, private key
+blocks, API tokens) block the push, whether in a directory or pushed on their
+own, including through a symlink. Prebuilt .tar.gz archives have their file
+names checked. Files over 1MB are only checked by name, with a warning. List
+intentional ones in .everywhere-allow-secrets (gitignore-style patterns) or
+pass --allow-secrets.
+
+Pushes are capped at 500MB total, 100MB per file and 20000 files by default,
+counting the contents of prebuilt archives. Adjust with --max-archive-size,
+--max-file-size and --max-files (0 for no limit), or set max_archive_size,
+max_file_size and max_files in ~/.everywhere/config.json.`,
 		Args: cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := requireAuth(); err != nil {
@@ -2420,7 +3860,11 @@ Use --include to override specific default exclusions (e.g. --include dist/).`,
 
 			archivePath := inputPath
 			if info.IsDir() {
-				tmp, err := createTarFromDir(inputPath, include...)
+				limits, err := resolveLimits()
+				if err != nil {
+					return err
+				}
+				tmp, err := createTarFromDir(inputPath, archiveOptions{Include: include, Dereference: dereference, AllowSecrets: allowSecrets, Limits: limits})
 				if err != nil {
 					return fmt.Errorf("failed to archive directory: %v", err)
 				}
@@ -2428,8 +3872,16 @@ Use --include to override specific default exclusions (e.g. --include dist/).`,
 				archivePath = tmp
 			} else {
 				lowerIn := strings.ToLower(inputPath)
-				if !(strings.HasSuffix(lowerIn, ".tar.gz") || strings.HasSuffix(lowerIn, ".tgz")) {
-					tmp, err := createTarFromFile(inputPath)
+				limits, err := resolveLimits()
+				if err != nil {
+					return err
+				}
+				if strings.HasSuffix(lowerIn, ".tar.gz") || strings.HasSuffix(lowerIn, ".tgz") {
+					if err := checkPrebuiltArchive(inputPath, allowSecrets, limits); err != nil {
+						return err
+					}
+				} else {
+					tmp, err := createTarFromFile(inputPath, allowSecrets, limits)
 					if err != nil {
 						return fmt.Errorf("failed to archive file: %v", err)
 					}
@@ -2454,14 +3906,47 @@ Use --include to override specific default exclusions (e.g. --include dist/).`,
 
 	cmd.Flags().StringVarP(&targetPath, "path", "p", "", "Target path in app (default: /home/user)")
 	cmd.Flags().StringArrayVar(&include, "include", nil, "Include patterns that would otherwise be excluded (e.g. --include dist/ --include build/)")
+	cmd.Flags().BoolVar(&dereference, "dereference", false, "Archive symlink targets instead of the links (allows links outside the directory)")
+	cmd.Flags().BoolVar(&allowSecrets, "allow-secrets", false, "Push even if files look like they contain credentials")
+	resolveLimits = addArchiveLimitFlags(cmd)
 	return cmd
 }
 
-func createTarFromFile(filePath string) (string, error) {
+// createTarFromFile creates a gzipped tar archive holding a single file, or
+// the target of a symlink under the link's name. The file is scanned for
+// likely credentials like createTarFromDir does, under both names for a
+// symlink, using the allowlist next to it.
+func createTarFromFile(filePath string, allowSecrets bool, limits archiveLimits) (string, error) {
 	info, err := os.Stat(filePath)
 	if err != nil {
 		return "", fmt.Errorf("stat file: %v", err)
 	}
+	budget := newArchiveBudget(limits)
+	if budget.add(filepath.Base(filePath), info.Size()) {
+		return "", budget.err()
+	}
+
+	in, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	name := filepath.Base(filePath)
+	secrets := newSecretScanner(filepath.Dir(filePath))
+	if err := secrets.scan(name, in, info.Size()); err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(filePath); err == nil && filepath.Base(real) != name {
+		secrets.scanName(name, filepath.Base(real))
+	}
+	if err := secrets.check(allowSecrets); err != nil {
+		return "", err
+	}
+	budget.warn()
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
 
 	f, err := os.CreateTemp("", "everywhere-upload-*.tar.gz")
 	if err != nil {
@@ -2472,22 +3957,15 @@ func createTarFromFile(filePath string) (string, error) {
 	gw := gzip.NewWriter(f)
 	tw := tar.NewWriter(gw)
 
-	header, err := tar.FileInfoHeader(info, "")
+	header, err := archiveHeader(info, name)
 	if err != nil {
 		return "", err
 	}
-	header.Name = filepath.Base(filePath)
 
 	if err := tw.WriteHeader(header); err != nil {
 		return "", err
 	}
 
-	in, err := os.Open(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer in.Close()
-
 	if _, err := io.Copy(tw, in); err != nil {
 		return "", err
 	}
@@ -2502,6 +3980,46 @@ func createTarFromFile(filePath string) (string, error) {
 	return f.Name(), nil
 }
 
+// checkPrebuiltArchive checks a prebuilt .tar.gz against the size limits and
+// its file names for likely credentials, using the allowlist next to it.
+// Contents are not scanned.
+func checkPrebuiltArchive(archivePath string, allowSecrets bool, limits archiveLimits) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read archive %s: %v", archivePath, err)
+	}
+	secrets := newSecretScanner(filepath.Dir(archivePath))
+	budget := newArchiveBudget(limits)
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive %s: %v", archivePath, err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			name := strings.TrimPrefix(path.Clean(h.Name), "./")
+			secrets.scanName(name, path.Base(name))
+			budget.add(name, h.Size)
+		}
+	}
+	if budget.exceeded() {
+		return budget.err()
+	}
+	if err := secrets.check(allowSecrets); err != nil {
+		return err
+	}
+	budget.warn()
+	return nil
+}
+
 // Logs command
 func newLogsCmd() *cobra.Command {
 	var follow bool
@@ -58,7 +58,8 @@ func initConfig() error {
 	viper.AutomaticEnv()
 	_ = viper.BindEnv("auth_token")
 	_ = viper.BindEnv("user_email")
-	_ = viper.BindEnv("api_url") // override endpoint via EVERYWHERE_API_URL
+	_ = viper.BindEnv("api_url")    // override endpoint via EVERYWHERE_API_URL
+	_ = viper.BindEnv("app_domain") // override app domain via EVERYWHERE_APP_DOMAIN
 
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok || os.IsNotExist(err) {
@@ -144,3 +145,9 @@ func SetAPIEndpoint(url string) error {
 	viper.Set("api_url", strings.TrimSpace(url))
 	return saveConfig()
 }
+
+// SetAppDomain updates the app domain in config; empty restores the default
+func SetAppDomain(domain string) error {
+	viper.Set("app_domain", strings.Trim(strings.TrimSpace(domain), "."))
+	return saveConfig()
+}
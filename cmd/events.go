@@ -0,0 +1,524 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// deployRenderer turns deploy events into output. handle returns true once
+// the deploy has finished, with an error if it failed.
+type deployRenderer interface {
+	handle(ev DeployEventStream) (bool, error)
+}
+
+// errVerboseNDJSON rejects -v with NDJSON output, which has every event
+// already and no room for verbose rendering.
+var errVerboseNDJSON = errors.New("-v cannot be used with --output ndjson, which already includes every event")
+
+// newDeployRenderer picks a renderer for opts.Output, writing to out.
+func newDeployRenderer(opts deployStreamOptions, out io.Writer) (deployRenderer, error) {
+	switch opts.Output {
+	case "", "pretty":
+		if opts.Verbose {
+			return &verboseDeployRenderer{out: out, baseDir: opts.BaseDir}, nil
+		}
+		return &prettyDeployRenderer{url: opts.URL, out: out}, nil
+	case "ndjson":
+		if opts.Verbose {
+			return nil, errVerboseNDJSON
+		}
+		return &ndjsonDeployRenderer{enc: json.NewEncoder(out), app: opts.App}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q (use pretty or ndjson)", opts.Output)
+	}
+}
+
+// renderDeployEvents feeds events to r until the deploy finishes or the
+// stream ends, and reports whether the deploy's outcome arrived. Every event
+// is appended to transcript when it is non-nil.
+func renderDeployEvents(events <-chan DeployEventStream, r deployRenderer, transcript *deployTranscript) (bool, error) {
+	for ev := range events {
+		if transcript != nil {
+			transcript.Events = append(transcript.Events, ev)
+		}
+		if done, err := r.handle(ev); done || err != nil {
+			finished, _ := deployOutcome(ev)
+			return finished, err
+		}
+	}
+	return false, nil
+}
+
+// deployTranscript is a saved deploy event stream, replayable with
+// 'everywhere deploy replay'.
+type deployTranscript struct {
+	App        string              `json:"app"`
+	URL        string              `json:"url,omitempty"`
+	WorkflowID string              `json:"workflow_id"`
+	SavedAt    string              `json:"saved_at"`
+	Incomplete bool                `json:"incomplete,omitempty"` // saved before the deploy's outcome arrived
+	Events     []DeployEventStream `json:"events"`
+}
+
+// finished reports whether t ends with the deploy's outcome.
+func (t *deployTranscript) finished() bool {
+	if len(t.Events) == 0 {
+		return false
+	}
+	done, _ := deployOutcome(t.Events[len(t.Events)-1])
+	return done
+}
+
+func (t *deployTranscript) save(path string) error {
+	t.SavedAt = time.Now().UTC().Format(time.RFC3339)
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Transcripts can hold build output with secrets in it
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// maxDeployEventSize bounds one event line in a deploy stream or NDJSON
+// transcript. Events carry full build output and diffs, so it is well above
+// bufio.Scanner's 64 KiB default.
+const maxDeployEventSize = 16 * 1024 * 1024
+
+// loadDeployTranscript reads a transcript saved with --transcript, or the
+// NDJSON written by --output ndjson.
+func loadDeployTranscript(path string) (*deployTranscript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t deployTranscript
+	if err := json.Unmarshal(data, &t); err == nil && t.Events != nil {
+		return &t, nil
+	}
+
+	t = deployTranscript{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxDeployEventSize)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var ev DeployEventStream
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			return nil, fmt.Errorf("%s:%d: not a deploy transcript or NDJSON event stream: %v", path, n, err)
+		}
+		t.Events = append(t.Events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ndjsonDeployRenderer writes every event, unfiltered, as one JSON object
+// per line.
+type ndjsonDeployRenderer struct {
+	enc *json.Encoder
+	app string // when set, added to each event as "app"
+}
+
+func (r *ndjsonDeployRenderer) handle(ev DeployEventStream) (bool, error) {
+	var v any = ev
+	if r.app != "" {
+		v = struct {
+			App string `json:"app"`
+			DeployEventStream
+		}{r.app, ev}
+	}
+	if err := r.enc.Encode(v); err != nil {
+		return true, err
+	}
+	return deployOutcome(ev)
+}
+
+// deployOutcome reports whether ev ends the deploy and whether it failed.
+func deployOutcome(ev DeployEventStream) (bool, error) {
+	switch ev.Type {
+	case "done":
+		if strings.Contains(ev.Message, "rolled back") {
+			return true, fmt.Errorf("deploy failed, rolled back")
+		}
+		return true, nil
+	case "error":
+		return true, fmt.Errorf("deploy failed")
+	}
+	return false, nil
+}
+
+// verboseDeployRenderer shows the whole agent loop: every event, grouped by
+// iteration, with full commands, outputs, file diffs and config values.
+type verboseDeployRenderer struct {
+	out       io.Writer
+	baseDir   string            // local project directory, used as the "before" side of diffs
+	iteration int               // iteration of the last event printed
+	written   map[string]string // file contents written earlier in this deploy
+	lastTool  string
+}
+
+func (r *verboseDeployRenderer) handle(ev DeployEventStream) (bool, error) {
+	if ev.Iteration > 0 && ev.Iteration != r.iteration {
+		r.iteration = ev.Iteration
+		fmt.Fprintf(r.out, "\n── Iteration %d ──\n", ev.Iteration)
+	}
+	ts := ""
+	if ev.Timestamp > 0 {
+		ts = time.UnixMilli(ev.Timestamp).Format("15:04:05") + " "
+	}
+
+	switch ev.Type {
+	case "tool_call":
+		r.lastTool = ev.Tool
+		fmt.Fprintf(r.out, "%s→ %s", ts, ev.Tool)
+		if ev.Message != "" && ev.Message != ev.Detail {
+			fmt.Fprintf(r.out, ": %s", ev.Message)
+		}
+		fmt.Fprintln(r.out)
+		switch ev.Tool {
+		case "run":
+			for line := range strings.SplitSeq(strings.TrimRight(ev.Detail, "\n"), "\n") {
+				fmt.Fprintf(r.out, "  $ %s\n", line)
+			}
+		case "write_file":
+			r.writeFile(ev.Detail)
+		case "update_entrypoint":
+			fmt.Fprintf(r.out, "  entrypoint = %s\n", ev.Detail)
+		case "set_upstream_port":
+			fmt.Fprintf(r.out, "  upstream port = %s\n", ev.Detail)
+		default:
+			r.indent(ev.Detail, "  ")
+		}
+	case "tool_result":
+		tool := ev.Tool
+		if tool == "" {
+			tool = r.lastTool
+		}
+		mark := "✓"
+		if !strings.HasPrefix(ev.Message, "OK") {
+			mark = "✗"
+		}
+		fmt.Fprintf(r.out, "%s  %s %s %s\n", ts, mark, tool, ev.Message)
+		r.indent(ev.Detail, "    │ ")
+	default:
+		fmt.Fprintf(r.out, "%s[%s] %s\n", ts, ev.Type, ev.Message)
+		r.indent(ev.Detail, "    ")
+	}
+	return deployOutcome(ev)
+}
+
+func (r *verboseDeployRenderer) indent(text, prefix string) {
+	if text == "" {
+		return
+	}
+	for line := range strings.SplitSeq(strings.TrimRight(text, "\n"), "\n") {
+		fmt.Fprintf(r.out, "%s%s\n", prefix, line)
+	}
+}
+
+// writeFile renders a write_file call. Its detail is the path, optionally
+// followed by the new contents on the next lines. The contents are diffed
+// against the last write of the same path in this deploy, or else the local
+// project file.
+func (r *verboseDeployRenderer) writeFile(detail string) {
+	path, content, hasContent := strings.Cut(detail, "\n")
+	fmt.Fprintf(r.out, "  %s\n", path)
+	if !hasContent {
+		return
+	}
+	if r.written == nil {
+		r.written = map[string]string{}
+	}
+	old, seen := r.written[path]
+	if !seen && r.baseDir != "" {
+		if b, err := os.ReadFile(filepath.Join(r.baseDir, filepath.FromSlash(strings.TrimPrefix(path, "/home/user/")))); err == nil {
+			old = string(b)
+		}
+	}
+	r.written[path] = content
+	for _, line := range lineDiff(old, content, 2) {
+		fmt.Fprintf(r.out, "    %s\n", line)
+	}
+}
+
+// maxDiffCells bounds the LCS table lineDiff builds; bigger inputs are shown
+// as a full replacement.
+const maxDiffCells = 4 << 20
+
+// lineDiff returns a unified-style line diff of a and b ("-", "+" and " "
+// prefixes) with context lines around each change and "@@" between hunks.
+func lineDiff(a, b string, context int) []string {
+	if a == b {
+		return []string{"(unchanged)"}
+	}
+	al, bl := splitLines(a), splitLines(b)
+
+	type op struct {
+		kind byte
+		line string
+	}
+	var ops []op
+	if (len(al)+1)*(len(bl)+1) > maxDiffCells {
+		for _, l := range al {
+			ops = append(ops, op{'-', l})
+		}
+		for _, l := range bl {
+			ops = append(ops, op{'+', l})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of al[i:] and bl[j:]
+		lcs := make([][]int, len(al)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(bl)+1)
+		}
+		for i := len(al) - 1; i >= 0; i-- {
+			for j := len(bl) - 1; j >= 0; j-- {
+				if al[i] == bl[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(al) || j < len(bl) {
+			switch {
+			case i < len(al) && j < len(bl) && al[i] == bl[j]:
+				ops = append(ops, op{' ', al[i]})
+				i++
+				j++
+			case i < len(al) && (j == len(bl) || lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, op{'-', al[i]})
+				i++
+			default:
+				ops = append(ops, op{'+', bl[j]})
+				j++
+			}
+		}
+	}
+
+	// Keep changes plus context lines around them
+	keep := make([]bool, len(ops))
+	for k, o := range ops {
+		if o.kind == ' ' {
+			continue
+		}
+		for c := max(0, k-context); c <= min(len(ops)-1, k+context); c++ {
+			keep[c] = true
+		}
+	}
+	var out []string
+	for k, o := range ops {
+		if !keep[k] {
+			continue
+		}
+		if k > 0 && !keep[k-1] && len(out) > 0 {
+			out = append(out, "@@")
+		}
+		out = append(out, string(o.kind)+" "+o.line)
+	}
+	return out
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// prettyDeployRenderer is the default human-oriented view: it hides noisy
+// steps and keeps each line short.
+type prettyDeployRenderer struct {
+	url           string // app URL printed when the deploy finishes
+	out           io.Writer
+	spinIdx       int
+	lastToolCall  string
+	lastRunDetail string // raw command from tool_call, shown with the timing on the result line
+}
+
+var spinChars = []rune{'⠋', '⠙', '⠹', '⠸', '⠼', '⠴', '⠦', '⠧', '⠇', '⠏'}
+
+func (r *prettyDeployRenderer) spin() rune {
+	return spinChars[r.spinIdx%len(spinChars)]
+}
+
+func (r *prettyDeployRenderer) handle(ev DeployEventStream) (bool, error) {
+	switch ev.Type {
+	case "step":
+		switch {
+		case ev.Message == "Thinking...":
+		case strings.HasPrefix(ev.Message, "Initializing"),
+			strings.HasPrefix(ev.Message, "Checking instance"),
+			strings.HasPrefix(ev.Message, "Instance ready"),
+			strings.HasPrefix(ev.Message, "Snapshotting"),
+			strings.HasPrefix(ev.Message, "Checking health"),
+			strings.HasPrefix(ev.Message, "Manifest deploy failed"):
+		case strings.HasPrefix(ev.Message, "Detected:"):
+			fmt.Fprintf(r.out, "  %s\n", ev.Message)
+		case strings.HasPrefix(ev.Message, "Found everywhere.json"):
+			fmt.Fprintln(r.out, "Using everywhere.json")
+		case ev.Message == "Generated everywhere.json":
+			fmt.Fprintln(r.out, "✓ Generated everywhere.json")
+		case ev.Message == "App output:" && ev.Detail != "":
+			// Show the last few meaningful lines from the crash log
+			lines := strings.Split(ev.Detail, "\n")
+			var errorLines []string
+			for i := len(lines) - 1; i >= 0 && len(errorLines) < 5; i-- {
+				line := strings.TrimSpace(lines[i])
+				if line == "" {
+					continue
+				}
+				errorLines = append([]string{line}, errorLines...)
+			}
+			if len(errorLines) > 0 {
+				fmt.Fprintln(r.out, "  App output:")
+				for _, line := range errorLines {
+					if len(line) > 120 {
+						line = line[:120] + "..."
+					}
+					fmt.Fprintf(r.out, "    %s\n", line)
+				}
+			}
+		default:
+			fmt.Fprintf(r.out, "%c %s\n", r.spin(), ev.Message)
+			r.spinIdx++
+		}
+	case "tool_call":
+		r.lastToolCall = ev.Tool
+		detail := ev.Detail
+		if len(detail) > 80 {
+			detail = detail[:80] + "..."
+		}
+		r.lastRunDetail = detail
+		switch ev.Tool {
+		case "run":
+			// Show semantic label if available, otherwise the command itself
+			if ev.Message != "" && ev.Message != detail {
+				fmt.Fprintf(r.out, "%c %s\n", r.spin(), ev.Message)
+			} else {
+				fmt.Fprintf(r.out, "%c %s\n", r.spin(), detail)
+			}
+		case "list_files", "get_env_info":
+			return false, nil // suppress noisy discovery steps
+		case "update_entrypoint", "set_upstream_port":
+			return false, nil // suppress config plumbing
+		case "write_file":
+			fmt.Fprintf(r.out, "%c Writing %s\n", r.spin(), detail)
+		default:
+			if detail != "" {
+				fmt.Fprintf(r.out, "%c %s: %s\n", r.spin(), ev.Message, detail)
+			} else {
+				fmt.Fprintf(r.out, "%c %s\n", r.spin(), ev.Message)
+			}
+		}
+		r.spinIdx++
+	case "tool_result":
+		switch {
+		case r.lastToolCall == "list_files" || r.lastToolCall == "get_env_info" ||
+			r.lastToolCall == "update_entrypoint" || r.lastToolCall == "set_upstream_port":
+			// Suppress results from discovery/config tools
+		case strings.HasPrefix(ev.Message, "OK"):
+			if ev.Tool == "run" {
+				// Extract timing if present: "OK (1.2s)"
+				timing := ""
+				if idx := strings.Index(ev.Message, "("); idx >= 0 {
+					timing = ev.Message[idx:]
+				}
+				// Show: "  npm install (4.1s)" — command + timing on result line
+				if timing != "" && r.lastRunDetail != "" {
+					fmt.Fprintf(r.out, "  %s %s\n", r.lastRunDetail, timing)
+				} else if timing != "" {
+					fmt.Fprintf(r.out, "  %s\n", timing)
+				} else if ev.Detail != "" {
+					// Fallback: show first line of command output
+					detail := ev.Detail
+					if idx := strings.Index(detail, "\n"); idx > 0 {
+						detail = detail[:idx]
+					}
+					if len(detail) > 100 {
+						detail = detail[:100] + "..."
+					}
+					if detail != "" {
+						fmt.Fprintf(r.out, "  %s\n", detail)
+					}
+				}
+			}
+		default:
+			// Failures — show most informative line of error output
+			fmt.Fprintf(r.out, "  ✗ %s\n", ev.Message)
+			if ev.Detail != "" {
+				// Find first line that contains an actual error, skipping noise
+				var errorLine string
+				for line := range strings.SplitSeq(ev.Detail, "\n") {
+					line = strings.TrimSpace(line)
+					if line == "" {
+						continue
+					}
+					// Skip noise lines (Go module headers, npm warnings)
+					if strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "npm warn") {
+						continue
+					}
+					errorLine = line
+					break
+				}
+				if errorLine == "" {
+					// Fallback to first non-empty line
+					for line := range strings.SplitSeq(ev.Detail, "\n") {
+						if strings.TrimSpace(line) != "" {
+							errorLine = strings.TrimSpace(line)
+							break
+						}
+					}
+				}
+				if len(errorLine) > 120 {
+					errorLine = errorLine[:120] + "..."
+				}
+				if errorLine != "" {
+					fmt.Fprintf(r.out, "    %s\n", errorLine)
+				}
+			}
+		}
+	case "done":
+		if strings.Contains(ev.Message, "rolled back") {
+			fmt.Fprintf(r.out, "✗ %s\n", ev.Message)
+			fmt.Fprintf(r.out, "  %s (previous version)\n", r.url)
+			return true, fmt.Errorf("deploy failed, rolled back")
+		}
+		if ev.Detail != "" {
+			fmt.Fprintf(r.out, "✓ Deployed in %s\n", ev.Detail)
+		} else {
+			fmt.Fprintln(r.out, "✓ Deployed")
+		}
+		fmt.Fprintf(r.out, "  %s\n", r.url)
+		return true, nil
+	case "error":
+		fmt.Fprintf(r.out, "✗ Deploy failed: %s\n", ev.Message)
+		return true, fmt.Errorf("deploy failed")
+	}
+	return false, nil
+}
@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gorillaws "github.com/gorilla/websocket"
+)
+
+// stdinIsPiped reports whether stdin is a pipe, socket or non-empty regular
+// file, i.e. the user is feeding the command data. Terminals, /dev/null and
+// the empty files some CI runners attach are not.
+func stdinIsPiped() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	mode := fi.Mode()
+	return mode&(os.ModeNamedPipe|os.ModeSocket) != 0 || mode.IsRegular() && fi.Size() > 0
+}
+
+// execStdin says what a remote command gets on stdin.
+type execStdin int
+
+const (
+	stdinNone   execStdin = iota
+	stdinPiped            // forwarded if the server supports it, else dropped
+	stdinForced           // -i: forwarded, or the command fails
+)
+
+// detectStdin picks the stdin mode for an exec, forced by -i.
+func detectStdin(interactive bool) execStdin {
+	switch {
+	case interactive:
+		return stdinForced
+	case stdinIsPiped():
+		return stdinPiped
+	}
+	return stdinNone
+}
+
+// execMessage is a JSON control message on the exec WebSocket.
+type execMessage struct {
+	Type string `json:"type"` // stdout, stderr, eof, exit or error
+	Data string `json:"data,omitempty"`
+	Code *int   `json:"code,omitempty"`
+}
+
+// streamExecWebSocket copies stdin to the remote command, signalling EOF when
+// it runs out, and the command's output to stdout and stderr. It returns the
+// command's exit code.
+func streamExecWebSocket(conn *gorillaws.Conn, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	var wsMu sync.Mutex
+	done := make(chan struct{})
+	defer close(done)
+	send := func(mt int, b []byte) error {
+		wsMu.Lock()
+		defer wsMu.Unlock()
+		select {
+		case <-done:
+			return net.ErrClosed
+		default:
+		}
+		return conn.WriteMessage(mt, b)
+	}
+
+	// Input: stdin -> WebSocket, then eof so the command sees end of input.
+	// A read still blocked when the command exits ends the goroutine as
+	// soon as it returns.
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := stdin.Read(buf)
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if n > 0 {
+				if send(gorillaws.BinaryMessage, buf[:n]) != nil {
+					return
+				}
+			}
+			if err != nil {
+				msg, _ := json.Marshal(execMessage{Type: "eof"})
+				send(gorillaws.TextMessage, msg)
+				return
+			}
+		}
+	}()
+
+	// Output: WebSocket -> stdout/stderr until the command exits
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			return 0, fmt.Errorf("exec connection closed before the command finished: %v", err)
+		}
+		if mt == gorillaws.BinaryMessage {
+			stdout.Write(msg)
+			continue
+		}
+		var m execMessage
+		if json.Unmarshal(msg, &m) != nil {
+			stdout.Write(msg)
+			continue
+		}
+		switch m.Type {
+		case "stdout":
+			io.WriteString(stdout, m.Data)
+		case "stderr":
+			io.WriteString(stderr, m.Data)
+		case "error":
+			return 0, fmt.Errorf("exec failed: %s", m.Data)
+		case "exit":
+			send(gorillaws.CloseMessage, gorillaws.FormatCloseMessage(gorillaws.CloseNormalClosure, ""))
+			if m.Code == nil {
+				return 0, nil
+			}
+			return *m.Code, nil
+		}
+	}
+}
+
+// execTimeoutGrace is how long past --timeout exec waits for the remote
+// timeout to report before giving up locally.
+var execTimeoutGrace = 10 * time.Second
+
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// execOptions are per-invocation settings for a remote command.
+type execOptions struct {
+	Env     map[string]string
+	Workdir string
+	Timeout time.Duration
+}
+
+// wrap returns command as a shell command line that applies o, e.g.
+//
+//	cd '/app' && export CI='1' && timeout -k 5 600 sh -c 'pytest'
+//
+// timeout(1) exits 124 when the limit is hit, or 137 when the command
+// ignores SIGTERM and is killed 5 seconds later. tty keeps the command in the
+// foreground so it can still read the terminal. Without a timeout the
+// command is grouped in braces, so a failed cd also stops commands after a
+// ";" or "||" in it.
+func (o execOptions) wrap(command string, tty bool) (string, error) {
+	var parts []string
+	if o.Workdir != "" {
+		parts = append(parts, "cd "+shellQuote(o.Workdir))
+	}
+	if len(o.Env) > 0 {
+		exports := make([]string, 0, len(o.Env))
+		for _, k := range slices.Sorted(maps.Keys(o.Env)) {
+			if !envNameRe.MatchString(k) {
+				return "", fmt.Errorf("invalid env name %q", k)
+			}
+			exports = append(exports, k+"="+shellQuote(o.Env[k]))
+		}
+		parts = append(parts, "export "+strings.Join(exports, " "))
+	}
+	if o.Timeout > 0 {
+		secs := int((o.Timeout + time.Second - 1) / time.Second)
+		foreground := ""
+		if tty {
+			foreground = "--foreground "
+		}
+		command = fmt.Sprintf("timeout %s-k 5 %d sh -c %s", foreground, secs, shellQuote(command))
+	} else if len(parts) > 0 {
+		command = "{ " + command + "\n}"
+	}
+	if len(parts) == 0 {
+		return command, nil
+	}
+	return strings.Join(append(parts, command), " && "), nil
+}
+
+// exitKilled is the status timeout(1) exits with when it has to SIGKILL a
+// command that ignored SIGTERM.
+const exitKilled = 128 + 9
+
+// timedOut reports whether a command that exited with code after elapsed
+// was stopped by --timeout. A command can exit 124 or 137 by itself, so only
+// one that ran for the whole timeout counts.
+func timedOut(code int, timeout, elapsed time.Duration) bool {
+	return timeout > 0 && (code == exitTimeout || code == exitKilled) && elapsed >= timeout
+}
+
+// execAttached runs command on instance with its output attached to the
+// local terminal: in a TTY, with stdin forwarded, or output only. It returns
+// the remote exit code. Cancelling ctx disconnects.
+func execAttached(ctx context.Context, client *APIClient, instance, command string, tty bool, stdin execStdin) (int, error) {
+	if !tty && stdin == stdinNone {
+		return client.StreamCommand(ctx, instance, command, os.Stdout, os.Stderr)
+	}
+
+	var conn *gorillaws.Conn
+	var err error
+	if tty {
+		conn, err = client.ConnectTerminalWebSocket(instance, command, "")
+	} else {
+		conn, err = client.ConnectExecWebSocket(instance, command)
+		var dialErr *wsDialError
+		if errors.As(err, &dialErr) && dialErr.StatusCode != http.StatusSwitchingProtocols && stdin == stdinPiped {
+			// Servers without stdin support run it as before, without input
+			fmt.Fprintln(os.Stderr, "Warning: this server cannot forward stdin to commands; running without it (use -i to require it)")
+			return client.StreamCommand(ctx, instance, command, os.Stdout, os.Stderr)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if tty {
+		return runTerminal(conn, terminalOptions{Keepalive: defaultKeepalive})
+	}
+	return streamExecWebSocket(conn, os.Stdin, os.Stdout, os.Stderr)
+}
+
+// scriptDir is where exec --script uploads scripts on the app.
+const scriptDir = "/tmp"
+
+// shebangInterpreter returns the interpreter and arguments of a script's
+// "#!" line, or sh when it has none.
+func shebangInterpreter(content string) []string {
+	line, _, _ := strings.Cut(content, "\n")
+	if rest, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "#!"); ok {
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"sh"}
+}
+
+// execScript is a local script prepared to run on an app.
+type execScript struct {
+	content string
+	remote  string // temp path on the app
+	command string // runs the remote copy, then deletes it
+}
+
+// newExecScript prepares content, a script named name, to run on an app
+// as interpreter followed by the script path and args.
+func newExecScript(name, content string, interpreter, args []string) *execScript {
+	id := make([]byte, 6)
+	rand.Read(id)
+	remote := fmt.Sprintf("%s/everywhere-script-%s-%s", scriptDir, hex.EncodeToString(id), filepath.Base(name))
+
+	var argv []string
+	for _, a := range append(append(slices.Clone(interpreter), remote), args...) {
+		argv = append(argv, shellQuote(a))
+	}
+	return &execScript{
+		content: content,
+		remote:  remote,
+		command: fmt.Sprintf("trap %s EXIT; %s", shellQuote("rm -f "+shellQuote(remote)), strings.Join(argv, " ")),
+	}
+}
+
+func (s *execScript) upload(client *APIClient, instance string) error {
+	if err := client.UpdateFile(instance, s.remote, s.content, "file", ""); err != nil {
+		return fmt.Errorf("failed to upload script: %v", err)
+	}
+	return nil
+}
+
+// remove deletes the remote copy, for when the script never got to run or
+// was killed before its EXIT trap could.
+func (s *execScript) remove(client *APIClient, instance string) {
+	client.RunCommand(instance, "rm -f "+shellQuote(s.remote))
+}
+
+// errSkipped marks apps --fail-fast stopped before they ran, and
+// errCancelled those it stopped waiting for while their command ran.
+var (
+	errSkipped   = errors.New("skipped after an earlier failure (--fail-fast)")
+	errCancelled = errors.New("cancelled after an earlier failure (--fail-fast); it may still be running on the app")
+)
+
+// execMany runs command on every target, at most parallel at once, with
+// output prefixed by app name, then prints each app's exit code. s, when
+// set, is uploaded to each app first. With failFast the first failure
+// cancels the rest.
+func execMany(client *APIClient, targets []string, command string, s *execScript, timeout time.Duration, parallel int, failFast bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := runTargets(targets, parallel, func(name string, out, errOut io.Writer) (string, error) {
+		if ctx.Err() != nil {
+			return "", errSkipped
+		}
+		code, stopped, err := execOne(ctx, client, name, command, s, timeout, out, errOut)
+		if err != nil && ctx.Err() != nil {
+			return "", errCancelled
+		}
+		if failFast && (err != nil || code != 0) {
+			cancel()
+		}
+		switch {
+		case err != nil:
+			return "", err
+		case stopped:
+			return strconv.Itoa(code), fmt.Errorf("timed out after %s", timeout)
+		case code != 0:
+			return strconv.Itoa(code), fmt.Errorf("exit status %d", code)
+		}
+		return "0", nil
+	})
+	return printTargetMatrix(os.Stdout, results, "EXIT")
+}
+
+// execOne runs command on one app of a fan-out, uploading s first if set,
+// and returns its exit code and whether the timeout stopped it.
+func execOne(ctx context.Context, client *APIClient, name, command string, s *execScript, timeout time.Duration, out, errOut io.Writer) (int, bool, error) {
+	if s != nil {
+		if err := s.upload(client, name); err != nil {
+			return 0, false, err
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout+execTimeoutGrace)
+		defer cancel()
+	}
+	start := time.Now()
+	code, err := client.StreamCommand(ctx, name, command, out, errOut)
+	stopped := ctx.Err() == context.DeadlineExceeded || (err == nil && timedOut(code, timeout, time.Since(start)))
+	if s != nil && (err != nil || stopped) {
+		s.remove(client, name)
+	}
+	if stopped {
+		return exitTimeout, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return code, false, nil
+}
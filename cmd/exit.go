@@ -0,0 +1,38 @@
+package cmd
+
+import "errors"
+
+// Exit statuses with a meaning beyond plain failure.
+const (
+	// exitTimeout mirrors timeout(1): the command gave up waiting.
+	exitTimeout = 124
+)
+
+// ExitError is an error that carries the status the CLI should exit with.
+// Err may be nil when the failure has already been reported.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return ""
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// ExitCode returns the process exit status for an error returned by the
+// root command: the code of an ExitError, 0 for nil and 1 otherwise.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return 1
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// gitRevision describes the commit a deploy was built from.
+type gitRevision struct {
+	Ref    string // what the user asked for, e.g. "main" or "v1.2.0"
+	Commit string // full SHA
+	Branch string // branch name, empty for tags and detached commits
+	Dirty  bool   // deployed files include uncommitted changes to tracked files
+}
+
+// runGit runs git in dir and returns trimmed stdout. Errors include git's
+// stderr so callers can surface them directly.
+func runGit(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// resolveGitRevision resolves ref to a commit in the repository containing dir.
+func resolveGitRevision(dir, ref string) (*gitRevision, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is required for --ref: %w", err)
+	}
+	if _, err := runGit(dir, "rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("%s is not inside a git repository", dir)
+	}
+	// No ref starts with "-"; refusing them keeps ref from being read as an
+	// option below, where rev-parse has no --end-of-options
+	if strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("unknown git revision %q", ref)
+	}
+	commit, err := runGit(dir, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	if err != nil || commit == "" {
+		return nil, fmt.Errorf("unknown git revision %q", ref)
+	}
+	rev := &gitRevision{Ref: ref, Commit: commit}
+
+	// Branch: from the symbolic name for branches, from HEAD otherwise
+	// when ref is HEAD itself.
+	if full, err := runGit(dir, "rev-parse", "--symbolic-full-name", ref); err == nil {
+		switch {
+		case strings.HasPrefix(full, "refs/heads/"):
+			rev.Branch = strings.TrimPrefix(full, "refs/heads/")
+		case strings.HasPrefix(full, "refs/remotes/"):
+			rev.Branch = strings.TrimPrefix(full, "refs/remotes/")
+		case full == "HEAD":
+			if b, err := runGit(dir, "rev-parse", "--abbrev-ref", "HEAD"); err == nil && b != "HEAD" {
+				rev.Branch = b
+			}
+		}
+	}
+
+	return rev, nil
+}
+
+// worktreeDirty reports whether the working tree containing dir has
+// uncommitted changes to tracked files.
+func worktreeDirty(dir string) (bool, error) {
+	status, err := runGit(dir, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return false, err
+	}
+	return status != "", nil
+}
+
+// shortSHA abbreviates a commit SHA for display.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// createTarFromGitRevision archives commit with git archive semantics (only
+// tracked files, export-ignore and export-subst honored), limited to dir's
+// subtree of the repository. The result goes through the same secret and
+// size checks as createTarFromDir.
+func createTarFromGitRevision(dir, commit string, opts archiveOptions) (string, error) {
+	prefix, err := runGit(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", err
+	}
+	toplevel, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"-C", toplevel, "archive", "--format=tar", commit}
+	if prefix != "" {
+		args = append(args, "--", prefix)
+	}
+	gitCmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	gitCmd.Stderr = &stderr
+	stdout, err := gitCmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := gitCmd.Start(); err != nil {
+		return "", fmt.Errorf("git archive: %w", err)
+	}
+
+	f, err := os.CreateTemp("", "everywhere-upload-*.tar.gz")
+	if err != nil {
+		gitCmd.Process.Kill()
+		gitCmd.Wait()
+		return "", fmt.Errorf("create temp archive: %v", err)
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	b := &tarBuilder{
+		tw:      tar.NewWriter(gw),
+		opts:    opts,
+		secrets: newSecretScanner(dir),
+		budget:  newArchiveBudget(opts.Limits),
+	}
+
+	err = b.repack(tar.NewReader(stdout), prefix)
+	if err != nil {
+		gitCmd.Process.Kill()
+	}
+	if waitErr := gitCmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("git archive: %s", strings.TrimSpace(stderr.String()))
+	}
+	if err == nil && len(b.escaping) > 0 {
+		// Unlike --local, there is no --dereference to fall back on
+		err = fmt.Errorf("symlinks in %s point outside %s:\n  %s", shortSHA(commit), dir, strings.Join(b.escaping, "\n  "))
+	}
+	if err == nil {
+		err = b.check(dir)
+	}
+	if err == nil {
+		err = b.tw.Close()
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// linkEscapes reports whether a symlink at name in an archive points outside
+// the archive, either by an absolute target or by climbing above its root.
+func linkEscapes(name, target string) bool {
+	if path.IsAbs(target) {
+		return true
+	}
+	resolved := path.Join(path.Dir(name), target)
+	return resolved == ".." || strings.HasPrefix(resolved, "../")
+}
+
+// repack copies entries from tr into the builder's writer, stripping prefix
+// from names and applying secret and size checks to regular files.
+func (b *tarBuilder) repack(tr *tar.Reader, prefix string) error {
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read git archive: %w", err)
+		}
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		// git archive also emits the directories leading to prefix, and
+		// prefix itself, which have no place in the archive
+		if !strings.HasPrefix(h.Name, prefix) {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(h.Name, prefix), "/")
+		if name == "" {
+			continue
+		}
+		h.Name = name
+		h.Uid, h.Gid = 0, 0
+		h.Uname, h.Gname = "", ""
+
+		if h.Typeflag == tar.TypeSymlink && linkEscapes(name, h.Linkname) {
+			b.escaping = append(b.escaping, name+" -> "+h.Linkname)
+			continue
+		}
+		if h.Typeflag != tar.TypeReg {
+			if err := b.tw.WriteHeader(h); err != nil {
+				return err
+			}
+			continue
+		}
+		if b.budget.add(name, h.Size) {
+			b.secrets.scanName(name, path.Base(name))
+			continue
+		}
+		if h.Size <= maxSecretScanSize {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err := b.secrets.scan(name, bytes.NewReader(data), h.Size); err != nil {
+				return err
+			}
+			if err := b.tw.WriteHeader(h); err != nil {
+				return err
+			}
+			if _, err := b.tw.Write(data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := b.secrets.scan(name, nil, h.Size); err != nil {
+			return err
+		}
+		if err := b.tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if _, err := io.Copy(b.tw, tr); err != nil {
+			return err
+		}
+	}
+}
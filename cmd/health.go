@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Health check defaults, used for any setting neither flags nor
+// everywhere.json provide.
+const (
+	defaultHealthStatus   = http.StatusOK
+	defaultHealthTimeout  = 5 * time.Second
+	defaultHealthRetries  = 5
+	defaultHealthInterval = 2 * time.Second
+)
+
+// healthCheck describes the HTTP probe run against an app after a deploy.
+type healthCheck struct {
+	Path       string        // request path, e.g. "/healthz"
+	Status     int           // expected response status
+	Body       string        // substring the response body must contain, if set
+	Timeout    time.Duration // per attempt
+	Retries    int           // attempts after the first
+	Interval   time.Duration // pause between attempts
+	noRollback bool
+}
+
+// manifestHealthCheck is the "health_check" object in everywhere.json.
+type manifestHealthCheck struct {
+	Path     string `json:"path"`
+	Status   *int   `json:"status"`
+	Body     string `json:"body"`
+	Timeout  string `json:"timeout"`
+	Retries  *int   `json:"retries"`
+	Interval string `json:"interval"`
+}
+
+// validHealthStatus reports whether status is one an HTTP response can have.
+func validHealthStatus(status int) bool {
+	return status >= 100 && status <= 599
+}
+
+// loadManifestHealthCheck reads the health_check section of dir's
+// everywhere.json. It returns nil when the file or section is missing.
+func loadManifestHealthCheck(dir string) (*manifestHealthCheck, error) {
+	m, err := loadProjectManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return m.HealthCheck, nil
+}
+
+// addHealthCheckFlags registers the health check flags on cmd and returns a
+// function that resolves the effective check for a project directory: flags
+// first, then health_check in dir/everywhere.json, then defaults. The
+// resolved check is nil when no path is configured.
+func addHealthCheckFlags(cmd *cobra.Command) func(dir string) (*healthCheck, error) {
+	var path, body string
+	var status, retries int
+	var timeout, interval time.Duration
+	var noRollback bool
+	cmd.Flags().StringVar(&path, "health-path", "", "After deploying, probe this HTTP path and roll back if it fails (e.g. /healthz)")
+	cmd.Flags().IntVar(&status, "health-status", 0, "Status the health check expects (default 200)")
+	cmd.Flags().StringVar(&body, "health-body", "", "Text the health check response body must contain")
+	cmd.Flags().DurationVar(&timeout, "health-timeout", 0, "Timeout for each health check attempt (default 5s)")
+	cmd.Flags().IntVar(&retries, "health-retries", 0, "Health check attempts after the first before giving up (default 5)")
+	cmd.Flags().DurationVar(&interval, "health-interval", 0, "Pause between health check attempts (default 2s)")
+	cmd.Flags().BoolVar(&noRollback, "no-rollback", false, "Report a failed health check without rolling back")
+
+	return func(dir string) (*healthCheck, error) {
+		hc := &healthCheck{
+			Status:     defaultHealthStatus,
+			Timeout:    defaultHealthTimeout,
+			Retries:    defaultHealthRetries,
+			Interval:   defaultHealthInterval,
+			noRollback: noRollback,
+		}
+		if dir != "" {
+			m, err := loadManifestHealthCheck(dir)
+			if err != nil {
+				return nil, err
+			}
+			if err := hc.apply(m); err != nil {
+				return nil, err
+			}
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("health-path") {
+			hc.Path = path
+		}
+		if flags.Changed("health-status") {
+			if !validHealthStatus(status) {
+				return nil, fmt.Errorf("invalid --health-status %d: must be an HTTP status from 100 to 599", status)
+			}
+			hc.Status = status
+		}
+		if flags.Changed("health-body") {
+			hc.Body = body
+		}
+		if flags.Changed("health-timeout") {
+			hc.Timeout = timeout
+		}
+		if flags.Changed("health-retries") {
+			hc.Retries = retries
+		}
+		if flags.Changed("health-interval") {
+			hc.Interval = interval
+		}
+		if hc.Path == "" {
+			return nil, nil
+		}
+		if hc.Retries < 0 {
+			return nil, fmt.Errorf("health check retries must be 0 or more, got %d", hc.Retries)
+		}
+		if !strings.HasPrefix(hc.Path, "/") {
+			hc.Path = "/" + hc.Path
+		}
+		return hc, nil
+	}
+}
+
+// apply overrides hc with the settings present in m, which may be nil.
+func (hc *healthCheck) apply(m *manifestHealthCheck) error {
+	if m == nil {
+		return nil
+	}
+	var err error
+	if m.Path != "" {
+		hc.Path = m.Path
+	}
+	if m.Body != "" {
+		hc.Body = m.Body
+	}
+	if m.Status != nil {
+		hc.Status = *m.Status
+	}
+	if m.Retries != nil {
+		hc.Retries = *m.Retries
+	}
+	if m.Timeout != "" {
+		if hc.Timeout, err = time.ParseDuration(m.Timeout); err != nil {
+			return fmt.Errorf("invalid health_check.timeout in everywhere.json: %v", err)
+		}
+	}
+	if m.Interval != "" {
+		if hc.Interval, err = time.ParseDuration(m.Interval); err != nil {
+			return fmt.Errorf("invalid health_check.interval in everywhere.json: %v", err)
+		}
+	}
+	return nil
+}
+
+// healthProbe is what one health check attempt saw.
+type healthProbe struct {
+	Status  int
+	Body    string // start of the response body
+	Err     error  // transport error, if the request failed
+	Latency time.Duration
+}
+
+// maxHealthBody bounds how much of a response body a probe reads.
+const maxHealthBody = 64 << 10
+
+// maxHealthBodyLine bounds, in runes, each line of a failed probe's body
+// shown in the report.
+const maxHealthBodyLine = 120
+
+// passed reports whether p meets hc's expectations, and if not, why.
+func (p healthProbe) passed(hc *healthCheck) (bool, string) {
+	switch {
+	case p.Err != nil:
+		return false, p.Err.Error()
+	case p.Status != hc.Status:
+		return false, fmt.Sprintf("status %d, want %d", p.Status, hc.Status)
+	case hc.Body != "" && !strings.Contains(p.Body, hc.Body):
+		return false, fmt.Sprintf("status %d, body does not contain %q", p.Status, hc.Body)
+	}
+	return true, fmt.Sprintf("status %d", p.Status)
+}
+
+// runHealthCheck probes url+hc.Path until it passes or the retries run out,
+// reporting each attempt to out. It returns the probes made and whether the
+// last one passed.
+func runHealthCheck(url string, hc *healthCheck, out io.Writer) ([]healthProbe, bool) {
+	client := &http.Client{Timeout: hc.Timeout}
+	target := strings.TrimRight(url, "/") + hc.Path
+	fmt.Fprintf(out, "Checking health: GET %s\n", target)
+
+	var probes []healthProbe
+	for attempt := 0; attempt <= hc.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(hc.Interval)
+		}
+		var p healthProbe
+		start := time.Now()
+		resp, err := client.Get(target)
+		p.Latency = time.Since(start)
+		if err != nil {
+			p.Err = err
+		} else {
+			b, _ := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+			resp.Body.Close()
+			p.Status = resp.StatusCode
+			p.Body = string(b)
+		}
+		probes = append(probes, p)
+
+		ok, why := p.passed(hc)
+		if ok {
+			fmt.Fprintf(out, "✓ Healthy: %s in %s (attempt %d/%d)\n", why, p.Latency.Round(time.Millisecond), attempt+1, hc.Retries+1)
+			return probes, true
+		}
+		fmt.Fprintf(out, "  attempt %d/%d: %s\n", attempt+1, hc.Retries+1, why)
+	}
+	return probes, false
+}
+
+// verifyDeploy runs hc against a freshly deployed app at url and, when it
+// fails, rolls back to snapshot, the one taken just before this deploy,
+// unless hc disables that. Without a snapshot the app is left as it is. A
+// nil hc skips the check.
+func verifyDeploy(client *APIClient, name, url, snapshot string, hc *healthCheck, out io.Writer) error {
+	if hc == nil {
+		return nil
+	}
+	probes, ok := runHealthCheck(url, hc, out)
+	if ok {
+		return nil
+	}
+
+	fmt.Fprintf(out, "✗ Health check failed after %d attempts\n", len(probes))
+	if len(probes) > 0 && probes[len(probes)-1].Err == nil {
+		if body := strings.TrimSpace(probes[len(probes)-1].Body); body != "" {
+			fmt.Fprintln(out, "  Last response body:")
+			for i, line := range strings.Split(body, "\n") {
+				if i == 5 {
+					fmt.Fprintln(out, "    ...")
+					break
+				}
+				fmt.Fprintf(out, "    %s\n", truncateRunes(line, maxHealthBodyLine))
+			}
+		}
+	}
+
+	if hc.noRollback {
+		return fmt.Errorf("health check failed for %s (not rolled back: --no-rollback)", name)
+	}
+	if snapshot == "" {
+		// Rolling back without this deploy's snapshot would restore some
+		// older, unrelated state
+		return fmt.Errorf("health check failed for %s (not rolled back: no rollback target is available, as no snapshot was taken before this deploy)", name)
+	}
+	fmt.Fprintln(out, "Rolling back...")
+	if _, err := client.Rollback(name, snapshot); err != nil {
+		return fmt.Errorf("health check failed for %s and rollback failed: %v", name, err)
+	}
+	fmt.Fprintf(out, "✓ Rolled back to snapshot %s\n", snapshot)
+	return fmt.Errorf("health check failed for %s, rolled back", name)
+}
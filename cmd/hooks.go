@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// deployHooks are local commands run around a deploy, configured in the
+// "hooks" object of everywhere.json:
+//
+//	"hooks": {
+//	  "pre_build": "npm run build",
+//	  "post_push": ["./scripts/notify.sh pushed"],
+//	  "post_deploy": "npm run migrate"
+//	}
+type deployHooks struct {
+	PreBuild   hookCommands `json:"pre_build"`
+	PostPush   hookCommands `json:"post_push"`
+	PostDeploy hookCommands `json:"post_deploy"`
+}
+
+// hookCommands is one hook: a single shell command or a list run in order.
+type hookCommands []string
+
+func (h *hookCommands) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		if one != "" {
+			*h = hookCommands{one}
+		}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("hook must be a command string or a list of commands")
+	}
+	*h = many
+	return nil
+}
+
+// runHook runs the commands of hook in dir through the shell, stopping at
+// the first failure. Output goes to out. env is added to the CLI's own
+// environment, along with EVERYWHERE_HOOK naming the hook.
+func runHook(hook string, cmds hookCommands, dir string, env []string, out io.Writer) error {
+	for _, command := range cmds {
+		fmt.Fprintf(out, "Running %s hook: %s\n", hook, command)
+		var c *exec.Cmd
+		if runtime.GOOS == "windows" {
+			c = exec.Command("cmd", "/C", command)
+		} else {
+			c = exec.Command("sh", "-c", command)
+		}
+		c.Dir = dir
+		c.Env = append(os.Environ(), "EVERYWHERE_HOOK="+hook)
+		c.Env = append(c.Env, env...)
+		c.Stdout = out
+		c.Stderr = out
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("%s hook %q failed: %v", hook, command, err)
+		}
+	}
+	return nil
+}
+
+// hookEnv builds the variables describing a deploy for hook commands.
+// Empty values are left out.
+func hookEnv(apps []string, app, url, workflowID, outcome string, rev *gitRevision) []string {
+	env := []string{"EVERYWHERE_APPS=" + strings.Join(apps, " ")}
+	add := func(k, v string) {
+		if v != "" {
+			env = append(env, k+"="+v)
+		}
+	}
+	add("EVERYWHERE_APP", app)
+	add("EVERYWHERE_APP_URL", url)
+	add("EVERYWHERE_WORKFLOW_ID", workflowID)
+	add("EVERYWHERE_OUTCOME", outcome)
+	if rev != nil {
+		add("EVERYWHERE_GIT_COMMIT", rev.Commit)
+		add("EVERYWHERE_GIT_REF", rev.Ref)
+	}
+	return env
+}
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Default push budgets, used when neither flags nor config set a limit.
+const (
+	defaultMaxArchiveSize = 500 << 20
+	defaultMaxFileSize    = 100 << 20
+	defaultMaxFiles       = 20000
+)
+
+// archiveWarnRatio is the fraction of a limit at which push starts warning
+// and printing the largest paths.
+const archiveWarnRatio = 0.8
+
+// archiveLimits bounds what push and deploy upload: directory archives,
+// single files and prebuilt archives alike. Zero means unlimited.
+// MaxArchiveSize applies to the total uncompressed size of archived files.
+type archiveLimits struct {
+	MaxArchiveSize int64
+	MaxFileSize    int64
+	MaxFiles       int
+}
+
+// addArchiveLimitFlags registers the size budget flags on cmd and returns a
+// function that resolves the effective limits: flags first, then config
+// (max_archive_size, max_file_size, max_files), then built-in defaults.
+func addArchiveLimitFlags(cmd *cobra.Command) func() (archiveLimits, error) {
+	var maxArchive, maxFile string
+	var maxFiles int
+	cmd.Flags().StringVar(&maxArchive, "max-archive-size", "", "Fail if files total more than this (e.g. 500MB; 0 for no limit)")
+	cmd.Flags().StringVar(&maxFile, "max-file-size", "", "Fail if any single file is larger than this (e.g. 100MB; 0 for no limit)")
+	cmd.Flags().IntVar(&maxFiles, "max-files", 0, "Fail if more than this many files would be pushed (0 for no limit)")
+
+	return func() (archiveLimits, error) {
+		var limits archiveLimits
+		var err error
+
+		archiveStr := viper.GetString("max_archive_size")
+		if cmd.Flags().Changed("max-archive-size") {
+			archiveStr = maxArchive
+		}
+		if limits.MaxArchiveSize, err = parseByteSize(archiveStr, defaultMaxArchiveSize); err != nil {
+			return limits, fmt.Errorf("invalid max archive size: %w", err)
+		}
+
+		fileStr := viper.GetString("max_file_size")
+		if cmd.Flags().Changed("max-file-size") {
+			fileStr = maxFile
+		}
+		if limits.MaxFileSize, err = parseByteSize(fileStr, defaultMaxFileSize); err != nil {
+			return limits, fmt.Errorf("invalid max file size: %w", err)
+		}
+
+		limits.MaxFiles = defaultMaxFiles
+		if viper.IsSet("max_files") {
+			limits.MaxFiles = viper.GetInt("max_files")
+		}
+		if cmd.Flags().Changed("max-files") {
+			limits.MaxFiles = maxFiles
+		}
+		if limits.MaxFiles < 0 {
+			return limits, fmt.Errorf("invalid max files %d: use 0 for no limit", limits.MaxFiles)
+		}
+		return limits, nil
+	}
+}
+
+// parseByteSize parses sizes like "500MB", "1.5G", "2048" (bytes). An empty
+// string yields def.
+func parseByteSize(s string, def int64) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" {
+		return def, nil
+	}
+	num := strings.TrimRight(s, "KMGTIB")
+	unit := strings.TrimSpace(s[len(num):])
+	num = strings.TrimSpace(num)
+	mult := int64(1)
+	switch strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I") {
+	case "":
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	default:
+		return 0, fmt.Errorf("unknown unit in %q", s)
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// formatByteSize renders n bytes with a binary unit, e.g. "1.5 GB".
+func formatByteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// archiveBudget tracks archive contents against archiveLimits.
+type archiveBudget struct {
+	limits    archiveLimits
+	files     int
+	total     int64
+	sizes     map[string]int64
+	oversized []string
+}
+
+func newArchiveBudget(limits archiveLimits) *archiveBudget {
+	return &archiveBudget{limits: limits, sizes: map[string]int64{}}
+}
+
+// add records a file and reports whether the archive is over budget, in
+// which case the caller should stop writing content (the walk continues so
+// the final report covers every offending path).
+func (b *archiveBudget) add(rel string, size int64) bool {
+	b.files++
+	b.total += size
+	b.sizes[rel] = size
+	if b.limits.MaxFileSize > 0 && size > b.limits.MaxFileSize {
+		b.oversized = append(b.oversized, rel)
+	}
+	return b.exceeded()
+}
+
+func (b *archiveBudget) exceeded() bool {
+	return len(b.oversized) > 0 ||
+		(b.limits.MaxArchiveSize > 0 && b.total > b.limits.MaxArchiveSize) ||
+		(b.limits.MaxFiles > 0 && b.files > b.limits.MaxFiles)
+}
+
+// nearLimit reports whether any limit is at least archiveWarnRatio used.
+func (b *archiveBudget) nearLimit() bool {
+	if b.limits.MaxArchiveSize > 0 && float64(b.total) >= archiveWarnRatio*float64(b.limits.MaxArchiveSize) {
+		return true
+	}
+	if b.limits.MaxFiles > 0 && float64(b.files) >= archiveWarnRatio*float64(b.limits.MaxFiles) {
+		return true
+	}
+	if b.limits.MaxFileSize > 0 {
+		for _, size := range b.sizes {
+			if float64(size) >= archiveWarnRatio*float64(b.limits.MaxFileSize) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// warn prints the usage and the largest paths to stderr when a limit is
+// nearly used up.
+func (b *archiveBudget) warn() {
+	if b.nearLimit() {
+		fmt.Fprintf(os.Stderr, "Warning: push is close to its size limits (%d files, %s)\n%s",
+			b.files, formatByteSize(b.total), b.summary())
+	}
+}
+
+// err describes every exceeded limit followed by the largest paths.
+func (b *archiveBudget) err() error {
+	var msg strings.Builder
+	msg.WriteString("push exceeds size limits:\n")
+	for _, rel := range b.oversized {
+		fmt.Fprintf(&msg, "  %s is %s (max file size %s)\n",
+			rel, formatByteSize(b.sizes[rel]), formatByteSize(b.limits.MaxFileSize))
+	}
+	if b.limits.MaxArchiveSize > 0 && b.total > b.limits.MaxArchiveSize {
+		fmt.Fprintf(&msg, "  files total %s (max archive size %s)\n",
+			formatByteSize(b.total), formatByteSize(b.limits.MaxArchiveSize))
+	}
+	if b.limits.MaxFiles > 0 && b.files > b.limits.MaxFiles {
+		fmt.Fprintf(&msg, "  %d files (max %d)\n", b.files, b.limits.MaxFiles)
+	}
+	msg.WriteString(b.summary())
+	msg.WriteString("Exclude large paths in .gitignore, or raise the limits with --max-file-size,\n")
+	msg.WriteString("--max-archive-size and --max-files (or max_file_size, max_archive_size and\n")
+	msg.WriteString("max_files in ~/.everywhere/config.json)")
+	return fmt.Errorf("%s", msg.String())
+}
+
+// summary lists the ten largest files and directories.
+func (b *archiveBudget) summary() string {
+	dirs := map[string]int64{}
+	for rel, size := range b.sizes {
+		for d := path.Dir(rel); d != "."; d = path.Dir(d) {
+			dirs[d+"/"] += size
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("Largest files:\n")
+	out.WriteString(topSizes(b.sizes, 10))
+	if len(dirs) > 0 {
+		out.WriteString("Largest directories:\n")
+		out.WriteString(topSizes(dirs, 10))
+	}
+	return out.String()
+}
+
+func topSizes(sizes map[string]int64, n int) string {
+	paths := make([]string, 0, len(sizes))
+	for p := range sizes {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if sizes[paths[i]] != sizes[paths[j]] {
+			return sizes[paths[i]] > sizes[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	var out strings.Builder
+	for _, p := range paths[:min(n, len(paths))] {
+		fmt.Fprintf(&out, "  %10s  %s\n", formatByteSize(sizes[p]), p)
+	}
+	return out.String()
+}
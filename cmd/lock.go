@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// deployLockPath is the lock file inside the app container. It is created
+// with noclobber so only one CLI can hold it at a time.
+const deployLockPath = "/home/user/.everywhere/deploy.lock"
+
+// Defaults for acquiring the deploy lock.
+const (
+	defaultLockTTL      = 30 * time.Minute
+	deployLockPollDelay = 5 * time.Second
+)
+
+// deployLock is the content of the lock file: who holds it and until when.
+type deployLock struct {
+	ID       string    `json:"id"`
+	Owner    string    `json:"owner"`
+	Host     string    `json:"host"`
+	PID      int       `json:"pid"`
+	Acquired time.Time `json:"acquired_at"`
+	Expires  time.Time `json:"expires_at"`
+
+	raw        string // file content as read, for compare-and-delete
+	unreadable bool   // the file is empty or not a lock we understand
+}
+
+func (l *deployLock) expired(now time.Time) bool {
+	return !l.Expires.IsZero() && now.After(l.Expires)
+}
+
+// describe renders the holder for messages, e.g.
+// "jane@example.com on laptop (pid 4242), since 3m ago, expires in 27m".
+func (l *deployLock) describe(now time.Time) string {
+	if l.unreadable {
+		return "unknown holder (empty or unreadable lock file)"
+	}
+	s := fmt.Sprintf("%s on %s", orDash(l.Owner), orDash(l.Host))
+	if l.PID != 0 {
+		s += fmt.Sprintf(" (pid %d)", l.PID)
+	}
+	if !l.Acquired.IsZero() {
+		s += ", since " + relativeTime(now, l.Acquired)
+	}
+	if !l.Expires.IsZero() {
+		if l.expired(now) {
+			s += ", expired"
+		} else {
+			s += ", expires in " + l.Expires.Sub(now).Round(time.Second).String()
+		}
+	}
+	return s
+}
+
+// lockOwner identifies the current user for the lock file.
+func lockOwner() string {
+	if email := GetUserEmail(); email != "" {
+		return email
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// readDeployLock returns the current lock on an app, or nil if unlocked.
+func readDeployLock(client *APIClient, app string) (*deployLock, error) {
+	out, err := client.RunCommand(app, fmt.Sprintf(`f=%s; if [ -e "$f" ]; then echo LOCKED; cat "$f"; fi`, deployLockPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deploy lock: %v", err)
+	}
+	status, rest, _ := strings.Cut(strings.TrimLeft(out, "\r\n"), "\n")
+	if strings.TrimSpace(status) != "LOCKED" {
+		return nil, nil
+	}
+	return parseDeployLock(rest), nil
+}
+
+// parseDeployLock parses the content of an existing lock file. A file that
+// is empty or not JSON still counts as a lock, held by nobody we know.
+func parseDeployLock(content string) *deployLock {
+	// Match what $(cat) gives removeDeployLock
+	content = strings.TrimRight(content, "\n")
+	l := &deployLock{raw: content}
+	if strings.TrimSpace(content) == "" || json.Unmarshal([]byte(content), l) != nil {
+		l.unreadable = true
+	}
+	return l
+}
+
+// tryAcquireDeployLock creates the lock file unless one exists. It returns
+// the existing lock when another holder has it.
+func tryAcquireDeployLock(client *APIClient, app string, lock *deployLock) (*deployLock, error) {
+	b, _ := json.Marshal(lock)
+	lock.raw = string(b)
+	script := fmt.Sprintf(`f=%s; mkdir -p "$(dirname "$f")"; if (set -C; printf '%%s' %s > "$f") 2>/dev/null; then echo ACQUIRED; else echo HELD; cat "$f"; fi`,
+		deployLockPath, shellQuote(lock.raw))
+	out, err := client.RunCommand(app, script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire deploy lock: %v", err)
+	}
+	status, rest, _ := strings.Cut(strings.TrimLeft(out, "\r\n"), "\n")
+	switch strings.TrimSpace(status) {
+	case "ACQUIRED":
+		return nil, nil
+	case "HELD":
+		return parseDeployLock(rest), nil
+	}
+	return nil, fmt.Errorf("failed to acquire deploy lock: unexpected output %q", strings.TrimSpace(out))
+}
+
+// removeDeployLock deletes the lock file if it still holds exactly the
+// content of l, so a lock taken over in the meantime is left alone.
+func removeDeployLock(client *APIClient, app string, l *deployLock) error {
+	script := fmt.Sprintf(`f=%s; if [ "$(cat "$f" 2>/dev/null)" = %s ]; then rm -f "$f"; fi`,
+		deployLockPath, shellQuote(l.raw))
+	_, err := client.RunCommand(app, script)
+	return err
+}
+
+// removeRestoredDeployLock deletes the lock file on app if it is one that a
+// deploy recorded with its snapshot in deploys, and so came back with a
+// rollback rather than from a deploy in progress.
+func removeRestoredDeployLock(client *APIClient, app string, deploys []map[string]any) error {
+	l, err := readDeployLock(client, app)
+	if err != nil || l == nil || l.unreadable {
+		return err
+	}
+	for _, d := range deploys {
+		raw := deployField(d, "deploy_lock")
+		if raw == "" {
+			continue
+		}
+		if recorded := parseDeployLock(raw); !recorded.unreadable && recorded.ID == l.ID {
+			return removeDeployLock(client, app, l)
+		}
+	}
+	return nil
+}
+
+// deployLockUnavailableError wraps failures to run the lock commands at all, as
+// opposed to finding the lock held.
+type deployLockUnavailableError struct{ err error }
+
+func (e deployLockUnavailableError) Error() string { return e.err.Error() }
+
+// acquireDeployLock takes the deploy lock on app for ttl, breaking expired
+// locks. If another holder has it, it waits up to wait (0 fails at once),
+// reporting the holder to out. Errors running the lock commands themselves
+// are returned as deployLockUnavailableError.
+func acquireDeployLock(client *APIClient, app string, ttl, wait time.Duration, out io.Writer) (*deployLock, error) {
+	host, _ := os.Hostname()
+	id := make([]byte, 8)
+	rand.Read(id)
+	deadline := time.Now().Add(wait)
+	announced, retried := false, false
+	for {
+		now := time.Now().UTC().Truncate(time.Second)
+		lock := &deployLock{
+			ID:       hex.EncodeToString(id),
+			Owner:    lockOwner(),
+			Host:     host,
+			PID:      os.Getpid(),
+			Acquired: now,
+			Expires:  now.Add(ttl),
+		}
+		held, err := tryAcquireDeployLock(client, app, lock)
+		if err != nil {
+			return nil, deployLockUnavailableError{err}
+		}
+		if held == nil {
+			return lock, nil
+		}
+		if held.raw == "" && !retried {
+			// Possibly released between our attempt and reading it; an
+			// empty file that stays is treated as held
+			retried = true
+			continue
+		}
+		if held.expired(time.Now()) {
+			fmt.Fprintf(out, "Breaking expired deploy lock held by %s\n", held.describe(time.Now()))
+			if err := removeDeployLock(client, app, held); err != nil {
+				return nil, fmt.Errorf("failed to break expired deploy lock: %v", err)
+			}
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%s is being deployed by %s\nWait with --lock-wait, or if that deploy is gone, run 'everywhere deploy unlock %s --force'",
+				app, held.describe(time.Now()), app)
+		}
+		if !announced {
+			fmt.Fprintf(out, "Waiting for deploy lock held by %s...\n", held.describe(time.Now()))
+			announced = true
+		}
+		time.Sleep(min(deployLockPollDelay, time.Until(deadline)))
+	}
+}
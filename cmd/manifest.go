@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// projectManifest holds the parts of a project's everywhere.json the CLI
+// itself reads. The rest of the file belongs to the deploy workflow.
+type projectManifest struct {
+	HealthCheck *manifestHealthCheck `json:"health_check"`
+	Hooks       deployHooks          `json:"hooks"`
+}
+
+// loadProjectManifest reads dir/everywhere.json. A missing file yields an
+// empty manifest.
+func loadProjectManifest(dir string) (*projectManifest, error) {
+	m := &projectManifest{}
+	data, err := os.ReadFile(filepath.Join(dir, "everywhere.json"))
+	if err != nil {
+		return m, nil
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid everywhere.json: %v", err)
+	}
+	if hc := m.HealthCheck; hc != nil && hc.Status != nil && !validHealthStatus(*hc.Status) {
+		return nil, fmt.Errorf("invalid health_check.status %d in everywhere.json: must be an HTTP status from 100 to 599", *hc.Status)
+	}
+	return m, nil
+}
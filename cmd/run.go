@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// runLanguage describes how "everywhere run" executes one language.
+type runLanguage struct {
+	Name        string   // --lang value
+	Aliases     []string // other accepted --lang values
+	Display     string   // for messages
+	Extensions  []string
+	Interpreter []string // runs a script file given as the next argument
+	Native      bool     // the server runs it itself via /instance/run
+}
+
+var runLanguages = []runLanguage{
+	{Name: "python", Aliases: []string{"py", "python3"}, Display: "Python", Extensions: []string{".py"}, Interpreter: []string{"python3"}, Native: true},
+	{Name: "javascript", Aliases: []string{"js", "node"}, Display: "JavaScript", Extensions: []string{".js", ".mjs", ".cjs"}, Interpreter: []string{"node"}},
+	{Name: "typescript", Aliases: []string{"ts"}, Display: "TypeScript", Extensions: []string{".ts", ".mts"}, Interpreter: []string{"npx", "--yes", "tsx"}},
+	{Name: "shell", Aliases: []string{"sh"}, Display: "shell", Extensions: []string{".sh"}, Interpreter: []string{"sh"}},
+	{Name: "bash", Display: "Bash", Extensions: []string{".bash"}, Interpreter: []string{"bash"}},
+	{Name: "ruby", Aliases: []string{"rb"}, Display: "Ruby", Extensions: []string{".rb"}, Interpreter: []string{"ruby"}},
+	{Name: "go", Aliases: []string{"golang"}, Display: "Go", Extensions: []string{".go"}, Interpreter: []string{"go", "run"}},
+}
+
+// shebangLanguages maps interpreter names on a "#!" line to languages.
+var shebangLanguages = map[string]string{
+	"python":  "python",
+	"node":    "javascript",
+	"nodejs":  "javascript",
+	"tsx":     "typescript",
+	"ts-node": "typescript",
+	"sh":      "shell",
+	"dash":    "shell",
+	"bash":    "bash",
+	"ruby":    "ruby",
+}
+
+// hasRunExtension reports whether path ends in a known language extension.
+func hasRunExtension(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, l := range runLanguages {
+		if slices.Contains(l.Extensions, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// runNatively reports whether a natively supported language can use the
+// server's runner, which takes neither arguments nor stdin. Piped stdin is
+// dropped on a temporary app, as before, since exec needs a named one.
+func runNatively(instance string, args []string) bool {
+	if len(args) > 0 {
+		return false
+	}
+	return instance == "auto" || !stdinIsPiped()
+}
+
+func runLanguageNames() string {
+	names := make([]string, len(runLanguages))
+	for i, l := range runLanguages {
+		names[i] = l.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func lookupRunLanguage(name string) (*runLanguage, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, l := range runLanguages {
+		if l.Name == name || slices.Contains(l.Aliases, name) {
+			return &runLanguages[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown language %q (supported: %s)", name, runLanguageNames())
+}
+
+// detectRunLanguage picks the language of a file from forceLang, then its
+// extension, then its "#!" line.
+func detectRunLanguage(path, content, forceLang string) (*runLanguage, error) {
+	if forceLang != "" {
+		return lookupRunLanguage(forceLang)
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	for i, l := range runLanguages {
+		if slices.Contains(l.Extensions, ext) {
+			return &runLanguages[i], nil
+		}
+	}
+	if strings.HasPrefix(content, "#!") {
+		interp := shebangInterpreter(content)
+		name := filepath.Base(interp[0])
+		if name == "env" {
+			// #!/usr/bin/env [-S] node
+			name = ""
+			for _, f := range interp[1:] {
+				if !strings.HasPrefix(f, "-") {
+					name = filepath.Base(f)
+					break
+				}
+			}
+		}
+		if lang, ok := shebangLanguages[strings.TrimRight(name, "0123456789.")]; ok {
+			return lookupRunLanguage(lang)
+		}
+	}
+	return nil, fmt.Errorf("cannot tell the language of %s; use --lang (supported: %s)", path, runLanguageNames())
+}
+
+// runOnApp uploads content, the program name, and runs it with lang's
+// interpreter through exec, forwarding args and piped stdin. It fails with
+// the program's exit status.
+func runOnApp(client *APIClient, instance, name, content string, lang *runLanguage, args []string) error {
+	if instance == "" || instance == "auto" {
+		return fmt.Errorf("running %s this way needs an app with the runtime installed; pass --app <name>", lang.Display)
+	}
+	// Keep stdout for the program's own output
+	fmt.Fprintf(os.Stderr, "Running %s as %s in app '%s'...\n", name, lang.Display, instance)
+
+	s := newExecScript(name, content, lang.Interpreter, args)
+	if err := s.upload(client, instance); err != nil {
+		return err
+	}
+	code, err := execAttached(context.Background(), client, instance, s.command, false, detectStdin(false))
+	if err != nil {
+		s.remove(client, instance)
+		return err
+	}
+	if code != 0 {
+		return &ExitError{Code: code}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secretsAllowFile lists paths (gitignore-style patterns, one per line) that
+// the pre-push secret scanner should not flag.
+const secretsAllowFile = ".everywhere-allow-secrets"
+
+// maxSecretScanSize bounds how much of each file is scanned for secret
+// patterns. Larger files are only checked by name.
+const maxSecretScanSize = 1 << 20
+
+// secretFileRules flag files whose name alone marks them as credentials.
+var secretFileRules = []struct {
+	pattern string
+	name    string
+}{
+	{".env", "dotenv file"},
+	{".env.*", "dotenv file"},
+	{"*.env", "dotenv file"},
+	{"id_rsa", "SSH private key"},
+	{"id_dsa", "SSH private key"},
+	{"id_ecdsa", "SSH private key"},
+	{"id_ed25519", "SSH private key"},
+	{"*.pem", "PEM certificate or key"},
+	{"*.key", "private key file"},
+	{"*.p12", "PKCS#12 keystore"},
+	{"*.pfx", "PKCS#12 keystore"},
+	{"*.jks", "Java keystore"},
+	{"*.keystore", "keystore"},
+	{".netrc", "netrc credentials"},
+	{".pgpass", "PostgreSQL password file"},
+	{"credentials", "credentials file"},
+	{"service-account*.json", "cloud service account key"},
+}
+
+// secretFileExceptions are template files that match secretFileRules but
+// conventionally hold placeholders only.
+var secretFileExceptions = []string{
+	".env.example", ".env.sample", ".env.template", ".env.dist",
+}
+
+// secretContentRules are high-confidence patterns for credentials embedded
+// in file contents.
+var secretContentRules = []struct {
+	re   *regexp.Regexp
+	name string
+}{
+	{regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`), "AWS access key ID"},
+	{regexp.MustCompile(`(?i)aws_secret_access_key\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`), "AWS secret access key"},
+	{regexp.MustCompile(`-----BEGIN (RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY( BLOCK)?-----`), "private key"},
+	{regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`), "GitHub token"},
+	{regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{82}\b`), "GitHub token"},
+	{regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20}\b`), "GitLab token"},
+	{regexp.MustCompile(`\bxox[baprs]-[0-9A-Za-z-]{10,}\b`), "Slack token"},
+	{regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{24,}\b`), "Stripe live key"},
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`), "Google API key"},
+	{regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_-]{32,}\b`), "Anthropic API key"},
+	{regexp.MustCompile(`\bsk-(proj-)?[A-Za-z0-9]{48,}\b`), "OpenAI API key"},
+}
+
+// secretFinding is a likely credential found while building an archive.
+type secretFinding struct {
+	Path string
+	Line int // 0 when the file name itself matched
+	Rule string
+}
+
+// secretScanner flags likely credentials in files being archived.
+type secretScanner struct {
+	allow     []string
+	findings  []secretFinding
+	unscanned []string // files too large to scan for content
+}
+
+// newSecretScanner loads the allowlist from root, if present.
+func newSecretScanner(root string) *secretScanner {
+	s := &secretScanner{}
+	if b, err := os.ReadFile(filepath.Join(root, secretsAllowFile)); err == nil {
+		for line := range strings.SplitSeq(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			s.allow = append(s.allow, line)
+		}
+	}
+	return s
+}
+
+// allowed reports whether the allowlist covers rel, directly or, as with
+// gitignore, through one of its parent directories.
+func (s *secretScanner) allowed(rel string) bool {
+	if shouldIgnore(rel, false, s.allow) {
+		return true
+	}
+	for dir := path.Dir(rel); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if shouldIgnore(dir, true, s.allow) {
+			return true
+		}
+	}
+	return false
+}
+
+// scan checks the file name and, for text files up to maxSecretScanSize, the
+// contents of r.
+func (s *secretScanner) scan(rel string, r io.Reader, size int64) error {
+	if s.allowed(rel) {
+		return nil
+	}
+	s.scanName(rel, filepath.Base(rel))
+
+	if size > maxSecretScanSize {
+		s.unscanned = append(s.unscanned, rel)
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxSecretScanSize))
+	if err != nil {
+		return err
+	}
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return nil // binary
+	}
+	for _, rule := range secretContentRules {
+		loc := rule.re.FindIndex(data)
+		if loc == nil {
+			continue
+		}
+		line := bytes.Count(data[:loc[0]], []byte("\n")) + 1
+		s.findings = append(s.findings, secretFinding{Path: rel, Line: line, Rule: rule.name})
+	}
+	return nil
+}
+
+// scanName checks a file name, reporting a match under rel.
+func (s *secretScanner) scanName(rel, base string) {
+	if s.allowed(rel) {
+		return
+	}
+	for _, e := range secretFileExceptions {
+		if base == e {
+			return
+		}
+	}
+	for _, rule := range secretFileRules {
+		if globMatch(rule.pattern, base) {
+			s.findings = append(s.findings, secretFinding{Path: rel, Rule: rule.name})
+			return
+		}
+	}
+}
+
+// check fails on any findings, or only warns about them on stderr when
+// allow is set (--allow-secrets).
+func (s *secretScanner) check(allow bool) error {
+	if len(s.unscanned) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d file(s) too large to scan for secrets (over %s):\n  %s\n",
+			len(s.unscanned), formatByteSize(maxSecretScanSize), strings.Join(s.unscanned, "\n  "))
+	}
+	if len(s.findings) == 0 {
+		return nil
+	}
+	if !allow {
+		return fmt.Errorf("possible secrets found in %d file(s):\n%s\nRemove them, add their paths to %s, or pass --allow-secrets to push anyway",
+			s.countFiles(), strings.TrimRight(s.report(), "\n"), secretsAllowFile)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: pushing %d file(s) with possible secrets (--allow-secrets):\n%s",
+		s.countFiles(), s.report())
+	return nil
+}
+
+// report formats findings as an aligned list, one per line.
+func (s *secretScanner) report() string {
+	width := 0
+	locs := make([]string, len(s.findings))
+	for i, f := range s.findings {
+		locs[i] = f.Path
+		if f.Line > 0 {
+			locs[i] = fmt.Sprintf("%s:%d", f.Path, f.Line)
+		}
+		width = max(width, len(locs[i]))
+	}
+	var b strings.Builder
+	for i, f := range s.findings {
+		fmt.Fprintf(&b, "  %-*s  %s\n", width, locs[i], f.Rule)
+	}
+	return b.String()
+}
+
+// countFiles returns the number of distinct files with findings.
+func (s *secretScanner) countFiles() int {
+	seen := map[string]bool{}
+	for _, f := range s.findings {
+		seen[f.Path] = true
+	}
+	return len(seen)
+}
@@ -1,122 +1,541 @@
 package cmd
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	gorillaws "github.com/gorilla/websocket"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
 
+// Terminal keepalive and reconnect defaults.
+const (
+	defaultKeepalive     = 30 * time.Second
+	maxReconnectAttempts = 10
+	maxReconnectDelay    = 15 * time.Second
+)
+
 func newSSHCmd() *cobra.Command {
-	return &cobra.Command{
+	var keepalive time.Duration
+	var reconnect bool
+	var escapeChar string
+	var record string
+	var recordInput bool
+
+	cmd := &cobra.Command{
 		Use:   "ssh <app>",
 		Short: "Open an interactive shell on an app",
-		Args:  cobra.ExactArgs(1),
+		Long: `Open an interactive shell on an app.
+
+The session pings the server every --keepalive so idle sessions are not cut
+by gateways, and a connection that stops answering is detected instead of
+hanging. With --reconnect, a dropped connection is re-dialed and reattached
+to the same shell when the server keeps it running.
+
+Like OpenSSH, the escape character (~ unless --escape-char says otherwise)
+is recognized at the start of a line:
+  ~.   disconnect
+  ~?   list escape sequences
+  ~^Z  suspend the CLI (resume with fg)
+  ~#   show session info
+  ~~   send a literal ~
+
+--record writes the session to an asciicast v2 file, readable only by you,
+for later review with "everywhere replay" or asciinema. Only output and
+resizes are recorded unless --record-input is given, as input can include
+passwords.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := requireAuth(); err != nil {
 				return err
 			}
+			if keepalive < 0 {
+				return fmt.Errorf("--keepalive must not be negative")
+			}
+			escape, err := parseEscapeChar(escapeChar)
+			if err != nil {
+				return err
+			}
+			if recordInput && record == "" {
+				return fmt.Errorf("--record-input requires --record")
+			}
 
 			client := NewAPIClient(GetAPIEndpoint(), GetAuthToken())
 
-			conn, err := client.ConnectTerminalWebSocket(args[0])
+			// The session ID lets the server reattach a reconnect to the
+			// shell it kept running
+			id := make([]byte, 8)
+			rand.Read(id)
+			session := hex.EncodeToString(id)
+			dial := func() (*gorillaws.Conn, error) {
+				return client.ConnectTerminalWebSocket(args[0], "", session)
+			}
+
+			conn, err := dial()
 			if err != nil {
 				return err
 			}
 			defer conn.Close()
 
-			// Put terminal in raw mode
-			fd := int(os.Stdin.Fd())
-			oldState, err := term.MakeRaw(fd)
-			if err != nil {
-				return fmt.Errorf("failed to set raw terminal: %w", err)
+			opts := terminalOptions{Keepalive: keepalive, EscapeChar: escape, App: args[0]}
+			if reconnect {
+				opts.Redial = dial
+			}
+			if record != "" {
+				f, err := os.OpenFile(record, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+				if err != nil {
+					return fmt.Errorf("failed to create recording: %v", err)
+				}
+				defer f.Close()
+				cols, rows, err := term.GetSize(int(os.Stdin.Fd()))
+				if err != nil {
+					cols, rows = 80, 24
+				}
+				rec, err := newCastRecorder(f, cols, rows, "everywhere ssh "+args[0], recordInput)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := rec.Close(); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+					}
+				}()
+				opts.Record = rec
+				fmt.Fprintf(os.Stderr, "Recording session to %s\n", record)
+			}
+			// Unlike exec -t, ssh exits 0 whatever the shell's status and
+			// only warns when the connection drops
+			if _, err := runTerminal(conn, opts); err != nil {
+				if !errors.Is(err, errTerminalLost) {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 			}
-			defer term.Restore(fd, oldState)
+			return nil
+		},
+	}
 
-			// Mutex to protect concurrent websocket writes
-			var wsMu sync.Mutex
+	cmd.Flags().DurationVar(&keepalive, "keepalive", defaultKeepalive, "Interval between keepalive pings (0 disables them)")
+	cmd.Flags().BoolVar(&reconnect, "reconnect", false, "Reconnect automatically when the connection drops")
+	cmd.Flags().StringVar(&escapeChar, "escape-char", "~", "Escape character: a single character, ^X for a control character, or none")
+	cmd.Flags().StringVar(&record, "record", "", "Record the session to an asciicast v2 file")
+	cmd.Flags().BoolVar(&recordInput, "record-input", false, "Also record what you type (may capture passwords)")
+	return cmd
+}
+
+// errTerminalLost wraps the errors of a terminal session whose connection
+// dropped before the remote process exited.
+var errTerminalLost = errors.New("terminal connection lost")
+
+// terminalExit is the message the server sends when the shell or command
+// exits. Code is missing from servers that predate exit statuses.
+type terminalExit struct {
+	Type string `json:"type"`
+	Code *int   `json:"code"`
+}
+
+// terminalOptions tune a terminal session.
+type terminalOptions struct {
+	// Keepalive is the ping interval; a connection silent for three
+	// intervals is treated as lost. 0 disables pings.
+	Keepalive time.Duration
+	// Redial opens a replacement connection. When set, a lost connection
+	// is re-dialed instead of ending the session.
+	Redial func() (*gorillaws.Conn, error)
+	// EscapeChar starts escape sequences at the beginning of a line; 0
+	// disables them.
+	EscapeChar byte
+	// App names the app in session info.
+	App string
+	// Record, when set, receives the session's output, input and resizes.
+	Record *castRecorder
+}
+
+// runTerminal attaches the local terminal to a terminal WebSocket in raw
+// mode, forwarding input and window size changes, until the remote process
+// exits. It returns the remote exit code.
+func runTerminal(conn *gorillaws.Conn, opts terminalOptions) (int, error) {
+	// Put terminal in raw mode
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to set raw terminal: %w", err)
+	}
+	defer term.Restore(fd, oldState)
+
+	s := &terminalSession{
+		conn:    conn,
+		opts:    opts,
+		in:      os.Stdin,
+		out:     os.Stdout,
+		status:  os.Stderr,
+		newline: "\r\n",
+		size: func() (int, int, bool) {
+			w, h, err := term.GetSize(fd)
+			return w, h, err == nil
+		},
+		suspend: func() {
+			// Hand the terminal back to the shell until we are resumed
+			term.Restore(fd, oldState)
+			syscall.Kill(os.Getpid(), syscall.SIGTSTP)
+			term.MakeRaw(fd)
+		},
+	}
 
-			// Send initial terminal size
-			if w, h, err := term.GetSize(fd); err == nil {
-				lockedSendResize(&wsMu, conn, w, h)
+	// Resize: SIGWINCH -> WebSocket
+	sigWinch := make(chan os.Signal, 1)
+	signal.Notify(sigWinch, syscall.SIGWINCH)
+	defer signal.Stop(sigWinch)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-sigWinch:
+				s.resize()
+			case <-done:
+				return
 			}
+		}
+	}()
 
-			done := make(chan struct{})
-			wsErr := make(chan error, 1)
+	return s.run()
+}
+
+// terminalSession pumps input and output between local streams and a
+// terminal WebSocket, replacing the connection when it drops if allowed.
+type terminalSession struct {
+	opts    terminalOptions
+	in      io.Reader
+	out     io.Writer
+	status  io.Writer // for session status lines
+	newline string    // "\r\n" in raw mode
+	size    func() (cols, rows int, ok bool)
+	suspend func() // stops the CLI until resumed, for ~^Z
+
+	// Mutex to protect the connection and concurrent websocket writes
+	mu           sync.Mutex
+	conn         *gorillaws.Conn
+	started      time.Time
+	reconnects   int
+	disconnected bool // the user asked to disconnect with ~.
+	dropping     bool // input is being dropped while the connection is down
+
+	statusMu sync.Mutex
+	stopOnce sync.Once
+	stopped  chan struct{} // closed on ~. or end of input, ending reconnects
+}
+
+func (s *terminalSession) current() *gorillaws.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+// resize sends the local terminal size, if known.
+func (s *terminalSession) resize() {
+	if s.size == nil {
+		return
+	}
+	if w, h, ok := s.size(); ok {
+		lockedSendResize(&s.mu, s.current(), w, h)
+		if s.opts.Record != nil {
+			s.opts.Record.resize(w, h)
+		}
+	}
+}
 
-			// Output: WebSocket -> stdout
-			go func() {
-				defer close(done)
-				for {
-					mt, msg, err := conn.ReadMessage()
-					if err != nil {
-						wsErr <- err
+func (s *terminalSession) statusf(format string, args ...any) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	fmt.Fprintf(s.status, s.newline+"[everywhere] "+format+s.newline, args...)
+}
+
+// stop ends any reconnect in progress.
+func (s *terminalSession) stop() {
+	s.stopOnce.Do(func() { close(s.stopped) })
+}
+
+// run forwards input and output until the remote process exits, and
+// returns its exit code.
+func (s *terminalSession) run() (int, error) {
+	s.started = time.Now()
+	s.stopped = make(chan struct{})
+	// Connections opened by reconnects are ours to close
+	defer func() { s.current().Close() }()
+	s.resize()
+
+	// Input: stdin -> WebSocket, across reconnects
+	go func() {
+		buf := make([]byte, 4096)
+		esc := &escapeFilter{char: s.opts.EscapeChar, lineStart: true}
+		for {
+			n, err := s.in.Read(buf)
+			if n > 0 {
+				data, actions := esc.filter(buf[:n])
+				if len(data) > 0 {
+					if s.opts.Record != nil {
+						s.opts.Record.inputData(data)
+					}
+					msg, _ := json.Marshal(map[string]any{
+						"type": "input",
+						"data": string(data),
+					})
+					s.mu.Lock()
+					writeErr := s.conn.WriteMessage(gorillaws.TextMessage, msg)
+					notify := writeErr != nil && !s.dropping
+					s.dropping = writeErr != nil
+					s.mu.Unlock()
+					if writeErr != nil && s.opts.Redial == nil {
 						return
 					}
-					// Server sends {"type":"exit"} as TextMessage when shell exits
-					if mt == gorillaws.TextMessage {
-						var signal map[string]string
-						if json.Unmarshal(msg, &signal) == nil && signal["type"] == "exit" {
-							return
+					if notify {
+						hint := ""
+						if s.opts.EscapeChar != 0 {
+							hint = fmt.Sprintf(" (%s. disconnects)", escapeCharName(s.opts.EscapeChar))
 						}
+						s.statusf("not connected; input is being dropped%s", hint)
 					}
-					os.Stdout.Write(msg)
 				}
-			}()
-
-			// Resize: SIGWINCH -> WebSocket
-			sigWinch := make(chan os.Signal, 1)
-			signal.Notify(sigWinch, syscall.SIGWINCH)
-			go func() {
-				for {
-					select {
-					case <-sigWinch:
-						if w, h, err := term.GetSize(fd); err == nil {
-							lockedSendResize(&wsMu, conn, w, h)
-						}
-					case <-done:
+				for _, a := range actions {
+					if !s.escape(a) {
 						return
 					}
 				}
-			}()
-
-			// Input: stdin -> WebSocket
-			go func() {
-				buf := make([]byte, 4096)
-				for {
-					n, err := os.Stdin.Read(buf)
-					if n > 0 {
-						msg, _ := json.Marshal(map[string]any{
-							"type": "input",
-							"data": string(buf[:n]),
-						})
-						wsMu.Lock()
-						writeErr := conn.WriteMessage(gorillaws.TextMessage, msg)
-						wsMu.Unlock()
-						if writeErr != nil {
-							return
-						}
-					}
-					if err != nil {
-						wsMu.Lock()
-						conn.WriteMessage(gorillaws.CloseMessage,
-							gorillaws.FormatCloseMessage(gorillaws.CloseNormalClosure, ""))
-						wsMu.Unlock()
-						return
-					}
+			}
+			if err != nil {
+				s.mu.Lock()
+				s.conn.WriteMessage(gorillaws.CloseMessage,
+					gorillaws.FormatCloseMessage(gorillaws.CloseNormalClosure, ""))
+				s.mu.Unlock()
+				s.stop()
+				return
+			}
+		}
+	}()
+
+	for {
+		code, exited, err := s.pump(s.current())
+		if s.isDisconnected() {
+			// Like OpenSSH, a session the user cut off exits with 255
+			return 255, nil
+		}
+		if exited || err == nil {
+			return code, nil
+		}
+		if s.opts.Redial == nil {
+			return 0, fmt.Errorf("%w: %v", errTerminalLost, err)
+		}
+		if err := s.reconnect(err); err != nil {
+			if s.isDisconnected() {
+				return 255, nil
+			}
+			return 0, err
+		}
+	}
+}
+
+// pump copies output from conn until the remote process exits (exited),
+// the server closes the connection normally (nil error) or it fails.
+func (s *terminalSession) pump(conn *gorillaws.Conn) (code int, exited bool, err error) {
+	stop := make(chan struct{})
+	defer close(stop)
+	if ka := s.opts.Keepalive; ka > 0 {
+		// Any message or pong proves the connection is alive
+		conn.SetReadDeadline(time.Now().Add(3 * ka))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(3 * ka))
+		})
+		go func() {
+			ticker := time.NewTicker(ka)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					conn.WriteControl(gorillaws.PingMessage, nil, time.Now().Add(ka))
+				case <-stop:
+					return
 				}
-			}()
+			}
+		}()
+	}
 
-			<-done
-			return nil
-		},
+	// Output: WebSocket -> stdout
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			if gorillaws.IsCloseError(err, gorillaws.CloseNormalClosure) {
+				return 0, false, nil
+			}
+			return 0, false, err
+		}
+		if ka := s.opts.Keepalive; ka > 0 {
+			conn.SetReadDeadline(time.Now().Add(3 * ka))
+		}
+		// Server sends {"type":"exit"} as TextMessage when shell exits
+		if mt == gorillaws.TextMessage {
+			var exit terminalExit
+			if json.Unmarshal(msg, &exit) == nil && exit.Type == "exit" {
+				if exit.Code != nil {
+					return *exit.Code, true, nil
+				}
+				return 0, true, nil
+			}
+		}
+		s.out.Write(msg)
+		if s.opts.Record != nil {
+			s.opts.Record.output(msg)
+		}
+	}
+}
+
+// reconnect replaces the lost connection, backing off between attempts. It
+// gives up early once the session is stopped.
+func (s *terminalSession) reconnect(cause error) error {
+	s.current().Close()
+	delay := time.Second
+	for attempt := 1; attempt <= maxReconnectAttempts; attempt++ {
+		s.statusf("connection lost (%v); reconnecting (attempt %d/%d)...", cause, attempt, maxReconnectAttempts)
+		select {
+		case <-time.After(delay):
+		case <-s.stopped:
+			return fmt.Errorf("%w: %v", errTerminalLost, cause)
+		}
+		delay = min(2*delay, maxReconnectDelay)
+
+		conn, err := s.opts.Redial()
+		if err != nil {
+			cause = err
+			continue
+		}
+		s.mu.Lock()
+		s.conn = conn
+		s.reconnects++
+		s.dropping = false
+		s.mu.Unlock()
+		s.statusf("reconnected")
+		s.resize()
+		return nil
+	}
+	return fmt.Errorf("%w, gave up after %d reconnect attempts: %v", errTerminalLost, maxReconnectAttempts, cause)
+}
+
+func (s *terminalSession) isDisconnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.disconnected
+}
+
+// escape carries out an escape sequence. It returns false once the session
+// is being disconnected.
+func (s *terminalSession) escape(a byte) bool {
+	esc := escapeCharName(s.opts.EscapeChar)
+	switch a {
+	case '.':
+		s.mu.Lock()
+		s.disconnected = true
+		conn := s.conn
+		s.mu.Unlock()
+		s.statusf("Connection to %s closed.", orDash(s.opts.App))
+		conn.Close()
+		s.stop()
+		return false
+	case '?':
+		s.statusf("Supported escape sequences:%s"+
+			" %[2]s.   - disconnect%[3]s"+
+			" %[2]s?   - this message%[3]s"+
+			" %[2]s^Z  - suspend everywhere%[3]s"+
+			" %[2]s#   - show session info%[3]s"+
+			" %[2]s%[2]s   - send the escape character%[3]s"+
+			"(Escape sequences are only recognized after a newline.)", s.newline, esc, s.newline)
+	case 0x1a: // ^Z
+		if s.suspend != nil {
+			s.statusf("suspending")
+			s.suspend()
+			s.resize()
+		}
+	case '#':
+		s.mu.Lock()
+		reconnects := s.reconnects
+		s.mu.Unlock()
+		s.statusf("Session to %s: connected %s, %d reconnects, keepalive %s, escape character %s",
+			orDash(s.opts.App), time.Since(s.started).Round(time.Second), reconnects, s.opts.Keepalive, esc)
+	}
+	return true
+}
+
+// escapeFilter finds OpenSSH-style escape sequences in terminal input: the
+// escape character at the start of a line followed by a command character.
+type escapeFilter struct {
+	char      byte // 0 disables escapes
+	lineStart bool // the next byte starts a line
+	pending   bool // saw the escape character at the start of a line
+}
+
+// filter returns the input to forward and the command characters of any
+// escape sequences, in order. An escape character at the end of b is held
+// until the next call.
+func (e *escapeFilter) filter(b []byte) ([]byte, []byte) {
+	if e.char == 0 {
+		return b, nil
+	}
+	var out, actions []byte
+	for _, c := range b {
+		switch {
+		case e.pending:
+			e.pending = false
+			switch c {
+			case '.', '?', '#', 0x1a:
+				actions = append(actions, c)
+				continue
+			case e.char:
+				out = append(out, c)
+			default:
+				// Not an escape: send both characters
+				out = append(out, e.char, c)
+			}
+		case e.lineStart && c == e.char:
+			e.pending = true
+			continue
+		default:
+			out = append(out, c)
+		}
+		e.lineStart = c == '\r' || c == '\n'
+	}
+	return out, actions
+}
+
+// parseEscapeChar parses --escape-char: one character, ^X for a control
+// character, or "none".
+func parseEscapeChar(s string) (byte, error) {
+	switch {
+	case s == "none":
+		return 0, nil
+	case len(s) == 1:
+		return s[0], nil
+	case len(s) == 2 && s[0] == '^' && s[1] >= '@' && s[1] <= '_':
+		return s[1] - '@', nil
+	case len(s) == 2 && s[0] == '^' && s[1] >= 'a' && s[1] <= 'z':
+		return s[1] - 'a' + 1, nil
+	}
+	return 0, fmt.Errorf("invalid --escape-char %q: use one character, ^X or none", s)
+}
+
+func escapeCharName(c byte) string {
+	if c < 0x20 {
+		return "^" + string(rune(c+'@'))
 	}
+	return string(rune(c))
 }
 
 func lockedSendResize(mu *sync.Mutex, conn *gorillaws.Conn, cols, rows int) {
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// resolveTargets combines app names given as arguments with those matching
+// selector, a glob over app names (e.g. "staging-*"). The result keeps
+// argument order, then adds selector matches sorted by name, without
+// duplicates.
+func resolveTargets(client *APIClient, names []string, selector string) ([]string, error) {
+	targets := slices.Clone(names)
+	if selector != "" {
+		if _, err := path.Match(selector, ""); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", selector, err)
+		}
+		instances, err := client.ListInstances()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list apps: %v", err)
+		}
+		var matched []string
+		for _, inst := range instances {
+			if ok, _ := path.Match(selector, inst.Name); ok {
+				matched = append(matched, inst.Name)
+			}
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no apps match selector %q", selector)
+		}
+		slices.Sort(matched)
+		targets = append(targets, matched...)
+	}
+
+	seen := map[string]bool{}
+	out := targets[:0]
+	for _, name := range targets {
+		if seen[name] {
+			continue
+		}
+		if err := validateInstanceName(name); err != nil {
+			return nil, err
+		}
+		seen[name] = true
+		out = append(out, name)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("requires at least one app name or --selector")
+	}
+	return out, nil
+}
+
+// targetResult is the outcome of one app in a multi-app operation.
+type targetResult struct {
+	Name     string
+	Err      error
+	Detail   string // e.g. the workflow ID
+	Duration time.Duration
+}
+
+// runTargets calls fn for every target with at most parallelism running at
+// once, and returns results in target order. Each call gets writers that
+// prefix every line with the app name.
+func runTargets(targets []string, parallelism int, fn func(name string, out, errOut io.Writer) (string, error)) []targetResult {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	width := 0
+	for _, name := range targets {
+		width = max(width, len(name))
+	}
+
+	var mu sync.Mutex // serializes lines from all targets
+	results := make([]targetResult, len(targets))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, name := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			prefix := fmt.Sprintf("%-*s | ", width, name)
+			out := &prefixWriter{w: os.Stdout, mu: &mu, prefix: prefix}
+			errOut := &prefixWriter{w: os.Stderr, mu: &mu, prefix: prefix}
+			start := time.Now()
+			detail, err := fn(name, out, errOut)
+			out.Flush()
+			errOut.Flush()
+			if err != nil {
+				errOut.Write([]byte("✗ " + err.Error() + "\n"))
+				errOut.Flush()
+			}
+			results[i] = targetResult{Name: name, Err: err, Detail: detail, Duration: time.Since(start)}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// printTargetMatrix prints a pass/fail table for results to out and returns
+// an error naming the failed targets, if any.
+func printTargetMatrix(out io.Writer, results []targetResult, detailHeader string) error {
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "APP\tRESULT\t%s\tDURATION\tERROR\n", detailHeader)
+	var failed []string
+	for _, r := range results {
+		result, errMsg := "✓ ok", "-"
+		if r.Err != nil {
+			result = "✗ failed"
+			errMsg, _, _ = strings.Cut(r.Err.Error(), "\n")
+			failed = append(failed, r.Name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Name, result, orDash(r.Detail),
+			r.Duration.Round(time.Second), errMsg)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d apps failed: %s", len(failed), len(results), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// prefixWriter writes complete lines to w, each preceded by prefix. Writes
+// from several prefixWriters sharing mu never interleave within a line.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes any trailing partial line.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	io.WriteString(p.w, p.prefix)
+	p.w.Write(line)
+}
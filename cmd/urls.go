@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// defaultAppDomain hosts apps for the default API endpoint.
+const defaultAppDomain = "somewhere.dev"
+
+// GetAppDomain returns the domain app URLs are built on when the server has
+// not provided one: app_domain from config (or EVERYWHERE_APP_DOMAIN), else
+// somewhere.dev for the hosted API, else the API host without its "api."
+// label for self-hosted endpoints.
+func GetAppDomain() string {
+	if d := strings.Trim(strings.TrimSpace(viper.GetString("app_domain")), "."); d != "" {
+		return d
+	}
+	u, err := url.Parse(GetAPIEndpoint())
+	if err != nil || u.Hostname() == "" {
+		return defaultAppDomain
+	}
+	def, _ := url.Parse(defaultAPIEndpoint)
+	host := u.Hostname()
+	if host == def.Hostname() {
+		return defaultAppDomain
+	}
+	return strings.TrimPrefix(host, "api.")
+}
+
+// appURLResolver works out the public URL of apps. URLs reported by the
+// server win; otherwise the URL is built from the tenant slug, when one is
+// claimed, and GetAppDomain.
+type appURLResolver struct {
+	client *APIClient // nil skips the tenant slug lookup
+
+	mu   sync.Mutex
+	urls map[string]string
+
+	slugOnce sync.Once
+	slug     string
+}
+
+func newAppURLResolver(client *APIClient) *appURLResolver {
+	return &appURLResolver{client: client, urls: map[string]string{}}
+}
+
+// remember records the URL in a server response such as UpdateVisibility's
+// (public_url, else app_url).
+func (r *appURLResolver) remember(name string, data map[string]any) {
+	for _, key := range []string{"public_url", "app_url"} {
+		if u, ok := data[key].(string); ok && strings.TrimSpace(u) != "" {
+			r.mu.Lock()
+			r.urls[name] = strings.TrimRight(strings.TrimSpace(u), "/")
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// url returns the public URL of app name.
+func (r *appURLResolver) url(name string) string {
+	r.mu.Lock()
+	u, ok := r.urls[name]
+	r.mu.Unlock()
+	if ok {
+		return u
+	}
+	r.slugOnce.Do(func() {
+		if r.client == nil {
+			return
+		}
+		// Best effort: without a slug, URLs fall back to app.domain
+		if result, err := r.client.GetTenantInfo(); err == nil {
+			if data, ok := result["data"].(map[string]any); ok {
+				r.slug, _ = data["slug"].(string)
+			}
+		}
+	})
+	return buildAppURL(name, r.slug, GetAppDomain())
+}
+
+// buildAppURL builds https://app.slug.domain, leaving out an empty slug.
+func buildAppURL(name, slug, domain string) string {
+	if slug != "" {
+		return fmt.Sprintf("https://%s.%s.%s", name, slug, domain)
+	}
+	return fmt.Sprintf("https://%s.%s", name, domain)
+}